@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/session"
+)
+
+// accessLevel orders the kinds of access a route can require.
+type accessLevel int
+
+const (
+	publicAccess accessLevel = iota
+	authenticatedAccess
+	roleAccess
+	ownerAccess
+)
+
+// Policy is the access a route requires, declared where the route is
+// registered in main.
+type Policy struct {
+	level accessLevel
+	// role is the least role required by role routes, and the least role
+	// that may use owner routes on resources owned by others.
+	role db.Role
+	// owner returns the user id owning the resource of an owner route.
+	owner func(a *App, r *http.Request) (string, error)
+}
+
+// Access policies for routes.
+var (
+	// Public routes can be used by anyone, including anonymous visitors.
+	Public = Policy{level: publicAccess}
+	// Authenticated routes require a logged in user.
+	Authenticated = Policy{level: authenticatedAccess}
+	// Moderator routes require a user that may manage any event.
+	Moderator = HasRole(db.RoleModerator)
+	// Admin routes require an admin, either by role or by being listed in
+	// AppConfig.
+	Admin = HasRole(db.RoleAdmin)
+)
+
+// HasRole returns a policy for routes that require a user with at least
+// the given role.
+func HasRole(role db.Role) Policy {
+	return Policy{level: roleAccess, role: role}
+}
+
+// OwnerOr returns a policy for routes that the user owning the resource,
+// as returned by owner, and users with at least the given role may use.
+// Owner functions return sql.ErrNoRows if the resource does not exist.
+// Handlers of such routes rely on the policy and do not check the owner
+// again.
+func OwnerOr(role db.Role, owner func(a *App, r *http.Request) (string, error)) Policy {
+	return Policy{level: ownerAccess, role: role, owner: owner}
+}
+
+// eventCreator returns the creator of the event in the request path.
+func eventCreator(a *App, r *http.Request) (string, error) {
+	e, err := a.store.GetEvent(eventID(r))
+	if err != nil {
+		return "", err
+	}
+	return e.CreatorID, nil
+}
+
+// allow wraps h so that it is only reached by requests meeting policy.
+// Anonymous visitors to a protected page are sent to the login page, while
+// API clients receive a JSON error.
+func (a *App) allow(policy Policy, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if policy.level == publicAccess {
+			h(w, r)
+			return
+		}
+
+		p := requestProfile(r)
+		if p == nil {
+			if isAPIRequest(r) {
+				writeJSONError(w, http.StatusUnauthorized, "Not logged in")
+				return
+			}
+			loginPath := "/auth/login"
+			requestLog(r).WithField("requestURL", r.URL.Path).Infof("Redirecting to %s", loginPath)
+			// Return to the requested page after logging in. Only pages can
+			// be returned to, since the body of other requests is lost.
+			if r.Method == "GET" {
+				if err := a.rememberReturnPath(w, r, r.URL.RequestURI()); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			http.Redirect(w, r, loginPath, http.StatusSeeOther)
+			return
+		}
+
+		status, err := a.checkPolicy(policy, r, p)
+		if err != nil {
+			if isAPIRequest(r) {
+				writeJSONError(w, status, err.Error())
+				return
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// checkPolicy checks the requirements of policy beyond being logged in. On
+// failure it returns the HTTP status describing the error.
+func (a *App) checkPolicy(policy Policy, r *http.Request, p *session.Profile) (int, error) {
+	switch policy.level {
+	case roleAccess:
+		role, err := a.role(r, p)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !role.AtLeast(policy.role) {
+			return http.StatusForbidden, fmt.Errorf("Only users with the %s role may do this", policy.role)
+		}
+	case ownerAccess:
+		owner, err := policy.owner(a, r)
+		if err == sql.ErrNoRows {
+			return http.StatusNotFound, fmt.Errorf("Not found")
+		}
+		if err != nil {
+			logrus.WithError(err).Error("Failed to get resource owner")
+			return http.StatusInternalServerError, err
+		}
+		if owner == p.UserID {
+			return http.StatusOK, nil
+		}
+		if policy.role != "" {
+			role, err := a.role(r, p)
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+			if role.AtLeast(policy.role) {
+				return http.StatusOK, nil
+			}
+		}
+		return http.StatusForbidden, fmt.Errorf("Only the owner may do this")
+	}
+	return http.StatusOK, nil
+}
+
+// role returns the role of p, the logged in user of r. Users listed as
+// admins in AppConfig are always admins, so that there is someone to assign
+// roles.
+func (a *App) role(r *http.Request, p *session.Profile) (db.Role, error) {
+	if a.admins[p.UserID] {
+		return db.RoleAdmin, nil
+	}
+	u, err := a.user(r, p)
+	if err == sql.ErrNoRows {
+		return db.RoleMember, nil
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get user role")
+		return "", err
+	}
+	return u.Role, nil
+}
+
+// viewerRole returns the role of the logged in user, or the empty role of
+// anonymous visitors, which may do nothing beyond public routes.
+func (a *App) viewerRole(r *http.Request) db.Role {
+	p := requestProfile(r)
+	if p == nil {
+		return ""
+	}
+	role, err := a.role(r, p)
+	if err != nil {
+		return ""
+	}
+	return role
+}
+
+// isAPIRequest reports whether the request is for the JSON API.
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
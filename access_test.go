@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/session"
+)
+
+func TestCheckPolicyRoles(t *testing.T) {
+	a := newTestApp()
+	a.admins = map[string]bool{"config-admin": true}
+	for _, role := range db.Roles {
+		if err := a.store.SaveLogin(&db.User{ID: string(role)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.store.SetUserRole(string(role), role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	owner := func(*App, *http.Request) (string, error) { return "member", nil }
+
+	tests := []struct {
+		policy Policy
+		user   string
+		ok     bool
+	}{
+		{Authenticated, "member", true},
+		{Moderator, "organizer", false},
+		{Moderator, "moderator", true},
+		{Admin, "moderator", false},
+		{Admin, "admin", true},
+		{Admin, "config-admin", true},
+		{Admin, "unknown", false},
+		{OwnerOr(db.RoleAdmin, owner), "member", true},
+		{OwnerOr(db.RoleAdmin, owner), "moderator", false},
+		{OwnerOr(db.RoleAdmin, owner), "admin", true},
+		{OwnerOr(db.RoleModerator, owner), "organizer", false},
+		{OwnerOr(db.RoleModerator, owner), "moderator", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		status, err := a.checkPolicy(tt.policy, r, &session.Profile{UserID: tt.user})
+		if (err == nil) != tt.ok {
+			t.Errorf("%+v for %s: status %d, err %v, want ok = %v", tt.policy, tt.user, status, err, tt.ok)
+		}
+	}
+}
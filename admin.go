@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/gorilla/mux"
+)
+
+// adminUsersCount is the number of users listed at once in the admin area.
+const adminUsersCount = 100
+
+// AdminUsersGET handles GET requests for '/admin/users', listing the users
+// matching the 'q' query parameter along with their roles.
+func (a *App) AdminUsersGET(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	users, err := a.store.ListUsers(q, adminUsersCount)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list users")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for i := range users {
+		if a.admins[users[i].ID] {
+			users[i].Role = db.RoleAdmin
+		}
+	}
+
+	data := map[string]interface{}{
+		"Page":   "Admin",
+		"Users":  users,
+		"Roles":  db.Roles,
+		"Query":  q,
+		"Admins": a.admins,
+	}
+	a.renderTemplate(w, r, "admin.tmpl", data)
+}
+
+// AdminUserRolePOST handles POST requests for '/admin/users/{id}/role' by
+// assigning the role in the 'role' form field to the user.
+func (a *App) AdminUserRolePOST(w http.ResponseWriter, r *http.Request) {
+	role, err := db.ParseRole(r.FormValue("role"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := mux.Vars(r)["id"]
+	if a.admins[userID] {
+		http.Error(w, "This user is an admin in the app config, remove them there first", http.StatusConflict)
+		return
+	}
+	err = a.store.SetUserRole(userID, role)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to set user role")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"user":  userID,
+		"role":  role,
+		"admin": requestProfile(r).UserID,
+	}).Info("Assigned role")
+
+	redirect := "/admin/users"
+	if path, ok := safeReturnPath(r.FormValue("return_to")); ok {
+		redirect = path
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
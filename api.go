@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/session"
+)
+
+// apiError is the body of every error response from the JSON API.
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	// Fields describes the problem with each invalid field of a submitted
+	// event, keyed by field name.
+	Fields fieldErrors `json:"fields,omitempty"`
+}
+
+// writeJSONError writes an error response in the JSON API's error format.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]apiError{
+		"error": {Status: status, Message: message},
+	})
+}
+
+// acceptsJSON reports whether the Accept header of the request allows a
+// JSON response. A missing header accepts anything.
+func acceptsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+	for _, t := range []string{"application/json", "application/*", "*/*"} {
+		if strings.Contains(accept, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// api wraps a JSON API handler, rejecting requests that cannot accept a
+// JSON response. The handler receives the profile of the logged in user,
+// which is nil on public routes used by anonymous clients.
+func (a *App) api(h func(w http.ResponseWriter, r *http.Request, p *session.Profile)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsJSON(r) {
+			writeJSONError(w, http.StatusNotAcceptable, "This endpoint only produces application/json")
+			return
+		}
+		h(w, r, requestProfile(r))
+	}
+}
+
+// APIEventsGET handles GET requests for '/api/v1/events'. It accepts the
+// same filters as '/events'.
+func (a *App) APIEventsGET(w http.ResponseWriter, r *http.Request, p *session.Profile) {
+	topics, types, err := a.lookups()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	filter, err := parseEventFilter(r.URL.Query(), topics, types, a.viewerZone(r))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events, next, err := a.store.ListEvents(filter)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list events")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := map[string]interface{}{"events": events}
+	if next != nil {
+		resp["next"] = next.String()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// APIEventsPOST handles POST requests for '/api/v1/events' by creating an
+// event owned by the current user, taking place in their time zone unless
+// the body names another.
+func (a *App) APIEventsPOST(w http.ResponseWriter, r *http.Request, p *session.Profile) {
+	in, err := decodeEventInput(r)
+	if err == errUnsupportedMediaType {
+		writeJSONError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	e := &db.Event{CreatorID: p.UserID, TimeZone: a.viewerZone(r).String()}
+	in.apply(e)
+	errs, err := a.validateEvent(in, e, time.Time{})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+	if err = a.store.CreateEvent(e); err != nil {
+		logrus.WithError(err).Error("Failed to save event")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/events/%d", e.ID))
+	writeJSON(w, http.StatusCreated, e)
+}
+
+// APIEventGET handles GET requests for '/api/v1/events/{id}'.
+func (a *App) APIEventGET(w http.ResponseWriter, r *http.Request, p *session.Profile) {
+	e, err := a.visibleEvent(r, eventID(r))
+	if err == sql.ErrNoRows {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get event")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// APIEventPUT handles PUT and PATCH requests for '/api/v1/events/{id}'.
+// Only fields present in the body are changed, and only the event's
+// creator and moderators may change it.
+func (a *App) APIEventPUT(w http.ResponseWriter, r *http.Request, p *session.Profile) {
+	e, status, err := a.editedEvent(eventID(r))
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	in, err := decodeEventInput(r)
+	if err == errUnsupportedMediaType {
+		writeJSONError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	oldStart := e.Start
+	in.apply(e)
+	errs, err := a.validateEvent(in, e, oldStart)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+	if err = a.store.UpdateEvent(e); err != nil {
+		logrus.WithError(err).Error("Failed to update event")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, e)
+}
+
+// APIEventDELETE handles DELETE requests for '/api/v1/events/{id}'. Only
+// the event's creator and admins may delete it.
+func (a *App) APIEventDELETE(w http.ResponseWriter, r *http.Request, p *session.Profile) {
+	if err := a.store.DeleteEvent(eventID(r)); err != nil {
+		logrus.WithError(err).Error("Failed to delete event")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIAttendancePUT handles PUT requests for '/api/v1/events/{id}/attendance'
+// by marking the event for the current user.
+func (a *App) APIAttendancePUT(w http.ResponseWriter, r *http.Request, p *session.Profile) {
+	if status, err := a.updateAttendance(r, eventID(r), p.UserID, a.store.Attend); err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIAttendanceDELETE handles DELETE requests for
+// '/api/v1/events/{id}/attendance' by unmarking the event for the current user.
+func (a *App) APIAttendanceDELETE(w http.ResponseWriter, r *http.Request, p *session.Profile) {
+	if status, err := a.updateAttendance(r, eventID(r), p.UserID, a.store.Unattend); err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIMyEventsGET handles GET requests for '/api/v1/me/events' by listing
+// the events the current user has marked.
+func (a *App) APIMyEventsGET(w http.ResponseWriter, r *http.Request, p *session.Profile) {
+	events, err := a.store.GetMyEvents(p.UserID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get marked events")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"events": events})
+}
+
+// APITopicsGET handles GET requests for '/api/v1/topics'.
+func (a *App) APITopicsGET(w http.ResponseWriter, r *http.Request, p *session.Profile) {
+	topics, err := a.store.EventTopics()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get event topics")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"topics": topics})
+}
+
+// APITypesGET handles GET requests for '/api/v1/types'.
+func (a *App) APITypesGET(w http.ResponseWriter, r *http.Request, p *session.Profile) {
+	types, err := a.store.EventTypes()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get event types")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"types": types})
+}
@@ -2,18 +2,24 @@ package main
 
 import (
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/identity"
+	"github.com/chloearianne/protestpulse/mailer"
 	"github.com/chloearianne/protestpulse/session"
 	"github.com/codegangsta/negroni"
 	"github.com/gorilla/handlers"
@@ -25,10 +31,25 @@ import (
 
 // App bundles resources used by the application.
 type App struct {
-	db          *db.Database
-	templateMap map[string]*template.Template
-	cookieStore *sessions.CookieStore
-	loginState  bool
+	db *db.Database
+	// store holds the users, events, tokens and accounts of the app. It
+	// is db, except in tests.
+	store        db.Store
+	templateMap  map[string]*template.Template
+	sessionStore sessions.Store
+	// location is the default time zone of new events and of users who
+	// have not chosen one.
+	location *time.Location
+	// domain is the app's domain name, used in identifiers that must not
+	// depend on the host a request was made to.
+	domain string
+	// admins is the set of user ids that are admins whatever their role.
+	admins map[string]bool
+	// providers are the identity providers users can log in with.
+	providers []identity.Provider
+	// localAccounts enables logging in with local email/password accounts.
+	localAccounts bool
+	mailer        mailer.Mailer
 }
 
 // AppConfig is a container for all app configuration parameters
@@ -36,6 +57,28 @@ type App struct {
 type AppConfig struct {
 	CookieKey string    `yaml:"cookie_key"`
 	DBConfig  db.Config `yaml:"db_config"`
+	// TimeZone is the IANA name of the default time zone of new events and
+	// of users who have not chosen one.
+	TimeZone string `yaml:"time_zone"`
+	// Domain is the domain name the app is served at. It is part of the
+	// calendar UIDs and feed ids of events, which must stay the same however
+	// the app is reached.
+	Domain string `yaml:"domain"`
+	// Admins lists the ids of users that are always admins, whatever their
+	// role, so that there is someone to assign roles.
+	Admins []string `yaml:"admins"`
+	// IdentityProviders lists the providers users can log in with. Auth0 is
+	// configured from the AUTH0_* environment variables if none are listed.
+	IdentityProviders []identity.Config `yaml:"identity_providers"`
+	// LocalAccounts enables signing up and logging in with an email address
+	// and password, as an alternative to the identity providers.
+	LocalAccounts bool `yaml:"local_accounts"`
+	// Mail configures how emails such as password resets are sent.
+	Mail mailer.Config `yaml:"mail"`
+	// SessionStore selects where login sessions are kept: "cookie" (the
+	// default) keeps them in the session cookie, "postgres" in the database,
+	// which allows logging out of all devices.
+	SessionStore string `yaml:"session_store"`
 }
 
 func main() {
@@ -46,47 +89,178 @@ func main() {
 	}
 
 	// Load AppConfig
-	c := loadConfig(fmt.Sprintf(os.Getenv("CONFIG_PATH")))
+	c := loadConfig(os.Getenv("CONFIG_PATH"))
 
 	// Set up the database
 	ppdb := db.New(c.DBConfig)
 	defer ppdb.Close()
 
+	location, err := loadZone(c.TimeZone)
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid time_zone")
+	}
+
+	// Migrate before any other setup, which may need the tables the
+	// migrations create.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCommand(ppdb, location, os.Args[2:]); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+	if c.Domain == "" {
+		logrus.Fatal("The domain setting is required")
+	}
+
+	providers, err := identity.NewProviders(identityConfigs(c))
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid identity_providers")
+	}
+
+	sessionStore, stopCleanup, err := newSessionStore(c, ppdb)
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid session_store")
+	}
+	defer stopCleanup()
+
 	// Create App object
 	app := App{
-		db:          ppdb,
-		cookieStore: sessions.NewCookieStore([]byte(c.CookieKey)),
-		templateMap: getTemplateMap(),
+		db:            ppdb,
+		store:         ppdb,
+		sessionStore:  sessionStore,
+		templateMap:   getTemplateMap(),
+		location:      location,
+		domain:        c.Domain,
+		admins:        map[string]bool{},
+		providers:     providers,
+		localAccounts: c.LocalAccounts,
+		mailer:        mailer.New(c.Mail),
+	}
+	for _, id := range c.Admins {
+		app.admins[id] = true
+	}
+
+	// Run a command line subcommand instead of the server if one is given.
+	if len(os.Args) > 1 {
+		if err := app.runCommand(os.Args[1:]); err != nil {
+			logrus.Fatal(err)
+		}
+		return
 	}
 
 	// Register types to be stored on session
 	gob.Register(map[string]interface{}{})
 	gob.Register(&session.Profile{})
 
-	// Set up routes
+	// Set up routes and the middleware stack
+	n := app.middleware(handlers.LoggingHandler(os.Stdout, app.router()))
+	n.Run(":" + os.Getenv("PORT"))
+}
+
+// router returns the router of all the app's routes, each wrapped with the
+// access policy it requires.
+func (a *App) router() *mux.Router {
 	r := mux.NewRouter()
 	// Handle authentication.
-	r.HandleFunc("/auth/logout", app.LogoutHandler)
-	r.HandleFunc("/auth/login", app.LoginHandler)
-	r.HandleFunc("/auth/callback", app.CallbackHandler)
+	r.HandleFunc("/auth/logout", a.allow(Public, a.LogoutHandler))
+	r.HandleFunc("/auth/login", a.allow(Public, a.LoginHandler))
+	r.HandleFunc("/auth/login/{provider}", a.allow(Public, a.LoginProviderHandler))
+	r.HandleFunc("/auth/callback", a.allow(Public, a.CallbackHandler))
+	r.HandleFunc("/auth/local/login", a.allow(Public, a.LocalLoginPOST)).Methods("POST")
+	r.HandleFunc("/auth/local/signup", a.allow(Public, a.SignupGET)).Methods("GET")
+	r.HandleFunc("/auth/local/signup", a.allow(Public, a.SignupPOST)).Methods("POST")
+	r.HandleFunc("/auth/local/forgot", a.allow(Public, a.ForgotPasswordGET)).Methods("GET")
+	r.HandleFunc("/auth/local/forgot", a.allow(Public, a.ForgotPasswordPOST)).Methods("POST")
+	r.HandleFunc("/auth/local/reset", a.allow(Public, a.ResetPasswordGET)).Methods("GET")
+	r.HandleFunc("/auth/local/reset", a.allow(Public, a.ResetPasswordPOST)).Methods("POST")
 	// Handle app routes.
-	r.HandleFunc("/", app.IndexGET).Methods("GET")
-	r.HandleFunc("/events", app.EventsGET).Methods("GET")
-	r.HandleFunc("/events", app.EventsPOST).Methods("POST")
-	r.HandleFunc("/events/{id}", app.EventGET).Methods("GET")
+	r.HandleFunc("/", a.allow(Authenticated, a.IndexGET)).Methods("GET")
+	r.HandleFunc("/preferences", a.allow(Authenticated, a.PreferencesGET)).Methods("GET")
+	r.HandleFunc("/preferences", a.allow(Authenticated, a.PreferencesPOST)).Methods("POST")
+	r.HandleFunc("/settings/tokens", a.allow(Authenticated, a.TokensGET)).Methods("GET")
+	r.HandleFunc("/settings/tokens", a.allow(Authenticated, a.TokensPOST)).Methods("POST")
+	r.HandleFunc("/settings/tokens/{id:[0-9]+}/revoke", a.allow(Authenticated, a.TokenRevokePOST)).Methods("POST")
+	r.HandleFunc("/settings/sessions", a.allow(Authenticated, a.SessionsGET)).Methods("GET")
+	r.HandleFunc("/settings/sessions/revoke", a.allow(Authenticated, a.SessionsRevokePOST)).Methods("POST")
+	r.HandleFunc("/settings/calendar", a.allow(Authenticated, a.CalendarGET)).Methods("GET")
+	r.HandleFunc("/settings/calendar/reset", a.allow(Authenticated, a.CalendarResetPOST)).Methods("POST")
+	// Calendar feeds are authenticated by the secret key in their URL.
+	r.HandleFunc("/calendar/{key}/{feed}.ics", a.allow(Public, a.CalendarFeedGET)).Methods("GET")
+	r.HandleFunc("/feeds/events.{format:atom|rss}", a.allow(Public, a.FeedGET)).Methods("GET")
+	r.HandleFunc("/feeds/{kind:topics|types}/{id:[0-9]+}.{format:atom|rss}", a.allow(Public, a.FeedGET)).Methods("GET")
+	r.HandleFunc("/search", a.allow(Public, a.SearchGET)).Methods("GET")
+	r.HandleFunc("/users/{id}", a.allow(Public, a.UserGET)).Methods("GET")
+	r.HandleFunc("/admin/users", a.allow(Admin, a.AdminUsersGET)).Methods("GET")
+	r.HandleFunc("/admin/users/{id}/role", a.allow(Admin, a.AdminUserRolePOST)).Methods("POST")
+	r.HandleFunc("/admin/users/{id}/sessions/revoke", a.allow(Admin, a.AdminSessionsRevokePOST)).Methods("POST")
+	r.HandleFunc("/events", a.allow(Public, a.EventsGET)).Methods("GET")
+	r.HandleFunc("/events", a.allow(Authenticated, a.EventsPOST)).Methods("POST")
+	r.HandleFunc("/events/import", a.allow(Authenticated, a.ImportGET)).Methods("GET")
+	r.HandleFunc("/events/import", a.allow(Authenticated, a.ImportPOST)).Methods("POST")
+	r.HandleFunc("/events/{id:[0-9]+}", a.allow(Public, a.EventGET)).Methods("GET")
+	r.HandleFunc("/events/{id:[0-9]+}.ics", a.allow(Public, a.EventICS)).Methods("GET")
+	r.HandleFunc("/events/{id:[0-9]+}", a.allow(OwnerOr(db.RoleModerator, eventCreator), a.EventPUT)).Methods("PUT", "PATCH", "POST")
+	r.HandleFunc("/events/{id:[0-9]+}", a.allow(OwnerOr(db.RoleAdmin, eventCreator), a.EventDELETE)).Methods("DELETE")
+	r.HandleFunc("/events/{id:[0-9]+}/delete", a.allow(OwnerOr(db.RoleAdmin, eventCreator), a.EventDELETE)).Methods("POST")
+	r.HandleFunc("/events/{id:[0-9]+}/hide", a.allow(Moderator, a.EventHidePOST)).Methods("POST")
+	r.HandleFunc("/events/{id:[0-9]+}/unhide", a.allow(Moderator, a.EventUnhidePOST)).Methods("POST")
+	r.HandleFunc("/events/{id:[0-9]+}/attend", a.allow(Authenticated, a.EventAttendPOST)).Methods("POST")
+	r.HandleFunc("/events/{id:[0-9]+}/unattend", a.allow(Authenticated, a.EventUnattendPOST)).Methods("POST")
+	// Handle the JSON API.
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/events", a.allow(Public, a.api(a.APIEventsGET))).Methods("GET")
+	api.HandleFunc("/events", a.allow(Authenticated, a.api(a.APIEventsPOST))).Methods("POST")
+	api.HandleFunc("/events/{id:[0-9]+}", a.allow(Public, a.api(a.APIEventGET))).Methods("GET")
+	api.HandleFunc("/events/{id:[0-9]+}", a.allow(OwnerOr(db.RoleModerator, eventCreator), a.api(a.APIEventPUT))).Methods("PUT", "PATCH")
+	api.HandleFunc("/events/{id:[0-9]+}", a.allow(OwnerOr(db.RoleAdmin, eventCreator), a.api(a.APIEventDELETE))).Methods("DELETE")
+	api.HandleFunc("/events/{id:[0-9]+}/attendance", a.allow(Authenticated, a.api(a.APIAttendancePUT))).Methods("PUT")
+	api.HandleFunc("/events/{id:[0-9]+}/attendance", a.allow(Authenticated, a.api(a.APIAttendanceDELETE))).Methods("DELETE")
+	api.HandleFunc("/me/events", a.allow(Authenticated, a.api(a.APIMyEventsGET))).Methods("GET")
+	api.HandleFunc("/topics", a.allow(Public, a.api(a.APITopicsGET))).Methods("GET")
+	api.HandleFunc("/types", a.allow(Public, a.api(a.APITypesGET))).Methods("GET")
+	return r
+}
 
-	// Set up middleware stack
+// middleware returns the middleware stack serving h. It must be used for
+// every request, since it attaches the request-scoped data handlers rely
+// on, such as the login state, to the request context.
+func (a *App) middleware(h http.Handler) *negroni.Negroni {
 	n := negroni.New(
+		negroni.HandlerFunc(RequestID),
 		negroni.NewRecovery(),
-		negroni.HandlerFunc(app.IsAuthenticated),
+		negroni.HandlerFunc(a.IsAuthenticated),
+		negroni.HandlerFunc(a.CSRFProtect),
 		negroni.NewStatic(http.Dir("public")),
 	)
-	n.UseHandler(handlers.LoggingHandler(os.Stdout, r))
-	n.Run(":" + os.Getenv("PORT"))
+	n.UseHandler(h)
+	return n
+}
+
+// sessionCleanupInterval is how often expired sessions are deleted from the
+// Postgres session store.
+const sessionCleanupInterval = time.Hour
+
+// newSessionStore returns the session store selected in the config, and a
+// function stopping its background work.
+func newSessionStore(c *AppConfig, ppdb *db.Database) (sessions.Store, func(), error) {
+	switch c.SessionStore {
+	case "", "cookie":
+		return sessions.NewCookieStore([]byte(c.CookieKey)), func() {}, nil
+	case "postgres":
+		store := session.NewPGStore(ppdb, []byte(c.CookieKey))
+		return store, store.StartCleanup(sessionCleanupInterval), nil
+	}
+	return nil, nil, fmt.Errorf("unknown session store %q", c.SessionStore)
 }
 
 // renderTemplate is a wrapper around template.ExecuteTemplate.
 func (a *App) renderTemplate(w http.ResponseWriter, r *http.Request, filename string, data map[string]interface{}) {
+	a.renderTemplateStatus(w, r, http.StatusOK, filename, data)
+}
+
+// renderTemplateStatus is like renderTemplate, but responds with the given
+// status code, such as when showing a form again with an error.
+func (a *App) renderTemplateStatus(w http.ResponseWriter, r *http.Request, status int, filename string, data map[string]interface{}) {
 	// Ensure the template exists in the map.
 	tmpl, ok := a.templateMap[filename]
 	if !ok {
@@ -95,18 +269,50 @@ func (a *App) renderTemplate(w http.ResponseWriter, r *http.Request, filename st
 		return
 	}
 
-	// Add loginState to data
-	data["LoggedIn"] = a.loginState
+	// Add the login state of the request to data
+	data["LoggedIn"] = loggedIn(r)
+	// Add the role of the user, which decides the actions offered
+	data["Role"] = a.viewerRole(r)
+	// Add an empty create event form, unless one is shown again with errors.
+	// New events take place in the viewer's time zone by default.
+	if _, ok := data["EventForm"]; !ok {
+		data["EventForm"] = &eventForm{Values: url.Values{"time_zone": {a.viewerZone(r).String()}}}
+	}
+
+	// Add the CSRF token for the forms of the page
+	token, err := a.csrfToken(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data["CSRFToken"] = token
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	err := tmpl.ExecuteTemplate(w, "base", data)
+	w.WriteHeader(status)
+	err = tmpl.ExecuteTemplate(w, "base", data)
 	if err != nil {
-		logrus.WithError(err).Error("Failed to ExecuteTemplate")
+		requestLog(r).WithError(err).Error("Failed to ExecuteTemplate")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+// wantsJSON reports whether the client prefers a JSON response, either
+// through the Accept header or a 'format=json' query parameter.
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeJSON encodes v as the JSON body of the response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithError(err).Error("Failed to encode JSON")
+	}
+}
+
 // getTemplateMap generates a map of template file name to complete templates.
 // If any failures occur when compiling the templates, a fatal error will be logged.
 func getTemplateMap() map[string]*template.Template {
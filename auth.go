@@ -2,154 +2,335 @@ package main
 
 import (
 	_ "crypto/sha512"
-	"encoding/json"
-	"fmt"
-	"html/template"
-	"io/ioutil"
+	"crypto/subtle"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/identity"
 	"github.com/chloearianne/protestpulse/session"
-
-	"golang.org/x/oauth2"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
 )
 
-// IsAuthenticated is middleware that checks to see whether the user is logged in.
+// IsAuthenticated is middleware that checks to see whether the user is logged in,
+// either through the auth-session or with an 'Authorization: Bearer' API token.
+// It does not restrict access; each route declares the access it requires
+// with a Policy when it is registered in main.
+//
+// The user's profile is added to the request context, which is where the
+// login state of each request is kept.
 func (a *App) IsAuthenticated(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	session, err := a.cookieStore.Get(r, "auth-session")
+	// The router only clears the gorilla context of the request it is given,
+	// which is a copy of this one once the context carries a profile.
+	defer context.Clear(r)
+
+	if token := bearerToken(r); token != "" {
+		t, status, msg := a.authenticateToken(r, token)
+		if status != http.StatusOK {
+			if isAPIRequest(r) {
+				writeJSONError(w, status, msg)
+				return
+			}
+			http.Error(w, msg, status)
+			return
+		}
+		next(w, withAPIToken(r, t))
+		return
+	}
+
+	s, err := a.sessionStore.Get(r, "auth-session")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if _, ok := session.Values["profile"]; !ok {
-		a.loginState = false
-		// Only redirect if not currently requesting an /auth/ route or any
-		// static assets to avoid an endless loop or blocking static resources.
-		if !strings.Contains(r.URL.Path, "/auth/") && !strings.Contains(r.URL.Path, "/static/") {
-			loginPath := "/auth/login"
-			logrus.WithField("requestURL", r.URL.Path).Infof("Redirecting to %s", loginPath)
-			// TODO - last thing seen is /callback so we need to pass the path forward to deep link
-			// http.Redirect(w, r, fmt.Sprintf("/auth/login?redir=%s", r.URL.Path), http.StatusSeeOther)
-			http.Redirect(w, r, loginPath, http.StatusSeeOther)
-			return
-		}
-	} else {
-		a.loginState = true
+	if p, ok := s.Values["profile"].(*session.Profile); ok && p != nil {
+		r = withProfile(r, p)
 	}
-
 	next(w, r)
 }
 
-// LoginHandler handles requests for '/auth/login'.
+// LoginHandler handles requests for '/auth/login' by listing the identity
+// providers users can log in with. A same-origin path in the 'return_to'
+// query parameter is remembered so that the user lands there once logged in.
 func (a *App) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if path, ok := safeReturnPath(r.URL.Query().Get("return_to")); ok {
+		if err := a.rememberReturnPath(w, r, path); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	data := map[string]interface{}{
-		"Page":              "Login",
-		"Auth0ClientId":     os.Getenv("AUTH0_CLIENT_ID"),
-		"Auth0ClientSecret": os.Getenv("AUTH0_CLIENT_SECRET"),
-		"Auth0Domain":       os.Getenv("AUTH0_DOMAIN"),
-		"Auth0CallbackURL":  template.URL(os.Getenv("AUTH0_CALLBACK_URL")),
+		"Page":          "Login",
+		"Providers":     a.providers,
+		"LocalAccounts": a.localAccounts,
 	}
 	a.renderTemplate(w, r, "login.tmpl", data)
 }
 
-// LogoutHandler handles requests for '/auth/logout' by logging
-// the user out from Auth0, clearing the session, and redirecting to login.
-func (a *App) LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	session, err := a.cookieStore.Get(r, "auth-session")
+// LoginProviderHandler handles requests for '/auth/login/{provider}' by
+// redirecting to the identity provider to log in.
+func (a *App) LoginProviderHandler(w http.ResponseWriter, r *http.Request) {
+	provider := a.provider(mux.Vars(r)["provider"])
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	session, err := a.sessionStore.Get(r, "auth-session")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Clear the session and cookie
-	delete(session.Values, "id_token")
-	delete(session.Values, "access_token")
-	delete(session.Values, "profile")
-	session.Options.MaxAge = -1
-	err = session.Save(r, w)
+	// Generate the state and nonce of this login, which CallbackHandler
+	// checks to make sure the callback and ID token belong to it.
+	state, err := randomToken()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	// Redirect to Auth0 logout endpoint followed by a redirect to login.
-	logoutPath := fmt.Sprintf("http://%s/v2/logout", os.Getenv("AUTH0_DOMAIN"))
-	logoutURL, err := url.Parse(logoutPath)
+	nonce, err := randomToken()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	q := logoutURL.Query()
-	q.Set("returnTo", fmt.Sprintf("http://%s/auth/login", r.Host))
-	q.Set("client_id", os.Getenv("AUTH0_CLIENT_ID"))
-	q.Set("secret", os.Getenv("AUTH0_CLIENT_SECRET"))
-	logoutURL.RawQuery = q.Encode()
 
-	http.Redirect(w, r, logoutURL.String(), http.StatusSeeOther)
-}
-
-// CallbackHandler will be called by Auth0 once it redirects to the app.
-func (a *App) CallbackHandler(w http.ResponseWriter, r *http.Request) {
-	domain := os.Getenv("AUTH0_DOMAIN")
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce)
+	if err != nil {
+		logrus.WithError(err).WithField("provider", provider.Name()).Error("Failed to start login")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
 
-	conf := &oauth2.Config{
-		ClientID:     os.Getenv("AUTH0_CLIENT_ID"),
-		ClientSecret: os.Getenv("AUTH0_CLIENT_SECRET"),
-		RedirectURL:  os.Getenv("AUTH0_CALLBACK_URL"),
-		Scopes:       []string{"openid", "profile"},
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  fmt.Sprintf("https://%s/authorize", domain),
-			TokenURL: fmt.Sprintf("https://%s/oauth/token", domain),
-		},
+	session.Values[oauthStateKey] = state
+	session.Values[oauthNonceKey] = nonce
+	session.Values[oauthProviderKey] = provider.Name()
+	if err = session.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
 
-	code := r.URL.Query().Get("code")
-	token, err := conf.Exchange(oauth2.NoContext, code)
+// LogoutHandler handles requests for '/auth/logout' by clearing the session
+// and logging the user out of their identity provider, which then redirects
+// back to login.
+func (a *App) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := a.sessionStore.Get(r, "auth-session")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	providerName, _ := session.Values[providerKey].(string)
+	idToken, _ := session.Values["id_token"].(string)
 
-	// Now getting the userinfo
-	client := conf.Client(oauth2.NoContext, token)
-	resp, err := client.Get(fmt.Sprintf("https://%s/userinfo", domain))
+	// Clear the session and cookie
+	delete(session.Values, "id_token")
+	delete(session.Values, "access_token")
+	delete(session.Values, "profile")
+	delete(session.Values, providerKey)
+	session.Options.MaxAge = -1
+	err = session.Save(r, w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	raw, err := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
+	// Redirect to the provider's logout endpoint followed by a redirect to
+	// login, or straight to login if the provider has none.
+	loginURL := baseURL(r) + "/auth/login"
+	logoutURL := ""
+	if provider := a.provider(providerName); provider != nil {
+		logoutURL, err = provider.LogoutURL(r.Context(), idToken, loginURL)
+		if err != nil {
+			logrus.WithError(err).WithField("provider", providerName).Error("Failed to get logout URL")
+		}
+	}
+	if logoutURL == "" {
+		logoutURL = "/auth/login"
+	}
+	http.Redirect(w, r, logoutURL, http.StatusSeeOther)
+}
+
+// CallbackHandler will be called by the identity provider once it redirects
+// to the app. The callback is rejected unless its state matches the one
+// that LoginProviderHandler stored in the auth-session.
+func (a *App) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := a.sessionStore.Get(r, "auth-session")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	expected, _ := session.Values[oauthStateKey].(string)
+	state := r.URL.Query().Get("state")
+	if expected == "" || subtle.ConstantTimeCompare([]byte(state), []byte(expected)) != 1 {
+		http.Error(w, "Invalid login state, please try logging in again", http.StatusForbidden)
+		return
+	}
+	nonce, _ := session.Values[oauthNonceKey].(string)
+	providerName, _ := session.Values[oauthProviderKey].(string)
+	// Each state may only be used once, so it is cleared even if the login
+	// fails.
+	delete(session.Values, oauthStateKey)
+	delete(session.Values, oauthNonceKey)
+	delete(session.Values, oauthProviderKey)
+	fail := func(msg string, status int) {
+		if err := session.Save(r, w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, msg, status)
+	}
+
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		if desc := r.URL.Query().Get("error_description"); desc != "" {
+			msg = desc
+		}
+		fail("Login failed: "+msg, http.StatusUnauthorized)
+		return
+	}
 
-	var profile *session.Profile
-	if err = json.Unmarshal(raw, &profile); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	provider := a.provider(providerName)
+	if provider == nil {
+		fail("Unknown identity provider, please try logging in again", http.StatusBadRequest)
 		return
 	}
 
-	session, err := a.cookieStore.Get(r, "auth-session")
+	login, err := provider.Exchange(r.Context(), r.URL.Query().Get("code"), nonce)
 	if err != nil {
+		logrus.WithError(err).WithField("provider", providerName).Error("Failed to log in")
+		fail(err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	a.logIn(w, r, session, login, providerName)
+}
+
+// logIn creates or updates the user in the database, stores the login in
+// the auth-session and redirects to the page the
+// user wanted before logging in, if any, otherwise to the logged in page.
+func (a *App) logIn(w http.ResponseWriter, r *http.Request, s *sessions.Session, login *identity.Login, providerName string) {
+	p := login.Profile
+	u := &db.User{
+		ID:          p.UserID,
+		DisplayName: strings.TrimSpace(p.GivenName + " " + p.FamilyName),
+		Email:       p.Email,
+		Picture:     p.Picture,
+	}
+	if err := a.store.SaveLogin(u); err != nil {
+		logrus.WithError(err).Error("Failed to save user")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	session.Values["id_token"] = token.Extra("id_token")
-	session.Values["access_token"] = token.AccessToken
-	session.Values["profile"] = profile
-	err = session.Save(r, w)
-	if err != nil {
+	// Stores keeping sessions on the server save the logged in session under
+	// a new id, so that an id planted before logging in cannot be used, and
+	// delete the session stored under the old one.
+	if revoker, ok := a.sessionStore.(session.Revoker); ok && s.ID != "" {
+		if err := revoker.RevokeSession(s.ID); err != nil {
+			logrus.WithError(err).Error("Failed to delete session")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	s.ID = ""
+	s.Values["id_token"] = login.IDToken
+	s.Values["access_token"] = login.AccessToken
+	s.Values["profile"] = login.Profile
+	s.Values[providerKey] = providerName
+
+	redirect := "/"
+	if path, ok := s.Values[returnToKey].(string); ok {
+		if path, ok = safeReturnPath(path); ok {
+			redirect = path
+		}
+	}
+	delete(s.Values, returnToKey)
+	if err := s.Save(r, w); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// provider returns the identity provider with the given name, or nil if
+// there is none.
+func (a *App) provider(name string) identity.Provider {
+	for _, p := range a.providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// identityConfigs returns the identity providers of c. Auth0 is configured
+// from the AUTH0_* environment variables if c lists none, unless local
+// accounts are enabled and the variables are not set.
+func identityConfigs(c *AppConfig) []identity.Config {
+	if len(c.IdentityProviders) > 0 {
+		return c.IdentityProviders
+	}
+	if c.LocalAccounts && os.Getenv("AUTH0_DOMAIN") == "" {
+		return nil
+	}
+	return []identity.Config{{
+		Name:         "auth0",
+		Type:         identity.TypeAuth0,
+		Label:        "Auth0",
+		Domain:       os.Getenv("AUTH0_DOMAIN"),
+		ClientID:     os.Getenv("AUTH0_CLIENT_ID"),
+		ClientSecret: os.Getenv("AUTH0_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("AUTH0_CALLBACK_URL"),
+	}}
+}
+
+// Keys of the auth-session values of the login in progress.
+const (
+	oauthStateKey    = "oauth_state"
+	oauthNonceKey    = "oauth_nonce"
+	oauthProviderKey = "oauth_provider"
+)
+
+// providerKey is the auth-session value holding the name of the identity
+// provider the user logged in with.
+const providerKey = "provider"
+
+// returnToKey is the auth-session value holding the path to return to after
+// logging in.
+const returnToKey = "return_to"
+
+// rememberReturnPath stores the path to return to after logging in.
+func (a *App) rememberReturnPath(w http.ResponseWriter, r *http.Request, path string) error {
+	session, err := a.sessionStore.Get(r, "auth-session")
+	if err != nil {
+		return err
+	}
+	session.Values[returnToKey] = path
+	return session.Save(r, w)
+}
 
-	// Redirect to logged in page
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+// safeReturnPath validates a path to return to after logging in. Only paths
+// on this site are allowed, so that the login flow cannot be used as an open
+// redirect to another site.
+func safeReturnPath(s string) (string, bool) {
+	if s == "" || !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.ContainsAny(s, "\\\r\n") {
+		return "", false
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "", false
+	}
+	// Never return to the login flow itself.
+	if strings.HasPrefix(u.Path, "/auth/") {
+		return "", false
+	}
+	return u.RequestURI(), true
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/identity"
+	"github.com/chloearianne/protestpulse/identity/identitytest"
+	"github.com/chloearianne/protestpulse/session"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+)
+
+const testCallbackURL = "http://app.example/auth/callback"
+
+// newLoginTestApp returns an App that logs in with the mock provider idp
+// under the name "mock", and a router for its auth routes.
+func newLoginTestApp(t *testing.T, idp *identitytest.Server) (*App, *mux.Router) {
+	a := newTestApp()
+	providers, err := identity.NewProviders([]identity.Config{idp.Config("mock", testCallbackURL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.providers = providers
+
+	r := mux.NewRouter()
+	r.HandleFunc("/auth/login", a.LoginHandler)
+	r.HandleFunc("/auth/login/{provider}", a.LoginProviderHandler)
+	r.HandleFunc("/auth/callback", a.CallbackHandler)
+	r.HandleFunc("/auth/logout", a.LogoutHandler)
+	return a, r
+}
+
+// withCookies returns r with the cookies set by rec added.
+func withCookies(r *http.Request, rec *httptest.ResponseRecorder) *http.Request {
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestLoginHandlerListsProviders(t *testing.T) {
+	idp := identitytest.NewServer()
+	defer idp.Close()
+	_, router := newLoginTestApp(t, idp)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/auth/login", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `href="/auth/login/mock"`) {
+		t.Error("login page does not link to the provider")
+	}
+}
+
+func TestLoginFlow(t *testing.T) {
+	idp := identitytest.NewServer()
+	defer idp.Close()
+	a, router := newLoginTestApp(t, idp)
+
+	// Start logging in, remembering the page to return to.
+	start := httptest.NewRecorder()
+	router.ServeHTTP(start, httptest.NewRequest("GET", "/auth/login?return_to=/events/3", nil))
+
+	login := httptest.NewRecorder()
+	router.ServeHTTP(login, withCookies(httptest.NewRequest("GET", "/auth/login/mock", nil), start))
+	if login.Code != http.StatusFound {
+		t.Fatalf("status = %d, body %q", login.Code, login.Body.String())
+	}
+	state, _ := sessionValue(t, a, login, oauthStateKey).(string)
+	if state == "" {
+		t.Fatal("no OAuth state stored in the auth-session")
+	}
+	authURL := login.Header().Get("Location")
+	if !strings.HasPrefix(authURL, idp.URL+"/authorize?") || !strings.Contains(authURL, "state="+state) {
+		t.Fatalf("redirected to %q", authURL)
+	}
+
+	// Sign in at the provider, which redirects back to the callback.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callbackURL, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback := httptest.NewRecorder()
+	router.ServeHTTP(callback, withCookies(httptest.NewRequest("GET", callbackURL.RequestURI(), nil), login))
+	if callback.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, body %q", callback.Code, callback.Body.String())
+	}
+	if loc := callback.Header().Get("Location"); loc != "/events/3" {
+		t.Errorf("redirected to %q, want %q", loc, "/events/3")
+	}
+	p, _ := sessionValue(t, a, callback, "profile").(*session.Profile)
+	if p == nil || p.UserID != "mock|"+idp.User.Subject {
+		t.Errorf("profile = %+v, want user %q", p, "mock|"+idp.User.Subject)
+	}
+	u, err := a.store.GetUser("mock|" + idp.User.Subject)
+	if err != nil {
+		t.Errorf("user was not saved: %v", err)
+	} else if u.Email != idp.User.Email || u.DisplayName != "Test User" {
+		t.Errorf("saved user = %+v", u)
+	}
+	if s, _ := sessionValue(t, a, callback, oauthStateKey).(string); s != "" {
+		t.Error("OAuth state was not cleared after use")
+	}
+
+	// Logging out goes through the provider's end session endpoint.
+	logout := httptest.NewRecorder()
+	router.ServeHTTP(logout, withCookies(httptest.NewRequest("GET", "/auth/logout", nil), callback))
+	if loc := logout.Header().Get("Location"); !strings.HasPrefix(loc, idp.URL+"/logout?") {
+		t.Errorf("logout redirected to %q", loc)
+	}
+}
+
+func TestLoginProviderHandlerUnknownProvider(t *testing.T) {
+	idp := identitytest.NewServer()
+	defer idp.Close()
+	_, router := newLoginTestApp(t, idp)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/auth/login/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestCallbackHandlerRejectsState(t *testing.T) {
+	idp := identitytest.NewServer()
+	defer idp.Close()
+	a, router := newLoginTestApp(t, idp)
+
+	tests := []struct {
+		name    string
+		stored  string
+		query   string
+		session bool
+	}{
+		{"no session", "", "?code=c&state=abc", false},
+		{"missing state", "abc", "?code=c", true},
+		{"wrong state", "abc", "?code=c&state=abd", true},
+		{"empty stored state", "", "?code=c&state=", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/auth/callback"+tt.query, nil)
+		if tt.session {
+			values := map[string]interface{}{oauthStateKey: tt.stored, oauthProviderKey: "mock"}
+			for _, c := range sessionCookies(t, a, values) {
+				r.AddCookie(c)
+			}
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	// The code must not be exchanged when the state is rejected.
+	if n := idp.TokenRequests(); n != 0 {
+		t.Errorf("provider got %d token requests, want 0", n)
+	}
+}
+
+// revokingStore is a cookie store that records the sessions revoked
+// through it, like stores keeping sessions on the server.
+type revokingStore struct {
+	*sessions.CookieStore
+	revoked []string
+}
+
+func (s *revokingStore) UserSessions(string) ([]db.Session, error) { return nil, nil }
+func (s *revokingStore) RevokeUserSessions(string) (int, error)    { return 0, nil }
+func (s *revokingStore) RevokeSession(id string) error {
+	s.revoked = append(s.revoked, id)
+	return nil
+}
+
+func TestLogInRevokesOldSession(t *testing.T) {
+	a := newTestApp()
+	store := &revokingStore{CookieStore: sessions.NewCookieStore([]byte("test-cookie-key"))}
+	a.sessionStore = store
+
+	// A session id planted before logging in must not stay usable.
+	r := httptest.NewRequest("GET", "/auth/callback", nil)
+	s, err := store.Get(r, "auth-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ID = "planted"
+	rec := httptest.NewRecorder()
+	a.logIn(rec, r, s, &identity.Login{Profile: &session.Profile{UserID: "user"}}, "mock")
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	if len(store.revoked) != 1 || store.revoked[0] != "planted" {
+		t.Errorf("revoked sessions = %v, want [planted]", store.revoked)
+	}
+	if s.ID == "planted" {
+		t.Error("the logged in session kept the planted id")
+	}
+}
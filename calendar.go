@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/ical"
+	"github.com/gorilla/mux"
+)
+
+// icalProdID identifies the app in the calendars it produces.
+const icalProdID = "-//Protest Pulse//Events//EN"
+
+// topicFeedHistory is how far back the followed topics feed reaches, so that
+// recent events do not disappear from calendars as soon as they start.
+const topicFeedHistory = 30 * 24 * time.Hour
+
+// calendarFeeds maps the name of each subscribable feed to its title.
+var calendarFeeds = map[string]string{
+	"attending": "My Protest Pulse events",
+	"created":   "Events I organize",
+	"topics":    "Events in topics I follow",
+}
+
+// EventICS handles GET requests for '/events/{id}.ics' by exporting a single
+// event as an iCalendar file.
+func (a *App) EventICS(w http.ResponseWriter, r *http.Request) {
+	e, err := a.visibleEvent(r, eventID(r))
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get event")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cal := &ical.Calendar{ProdID: icalProdID}
+	cal.Events = append(cal.Events, a.icalEvent(r, e))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"event-%d.ics\"", e.ID))
+	a.writeCalendar(w, cal)
+}
+
+// CalendarFeedGET handles GET requests for '/calendar/{key}/{feed}.ics'.
+// Calendar apps cannot log in, so the secret key in the URL identifies the
+// user instead of the auth-session.
+func (a *App) CalendarFeedGET(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := a.store.CalendarFeedUser(vars["key"])
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get calendar feed")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var events []db.Event
+	switch vars["feed"] {
+	case "attending":
+		events, err = a.store.EventsAttending(userID)
+	case "created":
+		events, err = a.store.EventsCreatedBy(userID)
+	case "topics":
+		events, err = a.store.EventsInUserTopics(userID, time.Now().Add(-topicFeedHistory))
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get calendar feed events")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cal := &ical.Calendar{ProdID: icalProdID, Name: calendarFeeds[vars["feed"]]}
+	for i := range events {
+		cal.Events = append(cal.Events, a.icalEvent(r, &events[i]))
+	}
+	a.writeCalendar(w, cal)
+}
+
+// CalendarGET handles GET requests for '/settings/calendar' by showing the
+// URLs of the user's calendar feeds.
+func (a *App) CalendarGET(w http.ResponseWriter, r *http.Request) {
+	p := requestProfile(r)
+
+	newKey, err := newFeedKey()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to generate calendar feed key")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key, err := a.store.CalendarFeedKey(p.UserID, newKey)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get calendar feed key")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feeds := map[string]string{}
+	for name := range calendarFeeds {
+		feeds[name] = fmt.Sprintf("%s/calendar/%s/%s.ics", baseURL(r), key, name)
+	}
+	data := map[string]interface{}{
+		"Page":  "Settings",
+		"Feeds": feeds,
+	}
+	a.renderTemplate(w, r, "calendar.tmpl", data)
+}
+
+// CalendarResetPOST handles POST requests for '/settings/calendar/reset' by
+// replacing the key of the user's calendar feeds.
+func (a *App) CalendarResetPOST(w http.ResponseWriter, r *http.Request) {
+	p := requestProfile(r)
+
+	newKey, err := newFeedKey()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to generate calendar feed key")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = a.store.ResetCalendarFeedKey(p.UserID, newKey); err != nil {
+		logrus.WithError(err).Error("Failed to reset calendar feed key")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/calendar", http.StatusSeeOther)
+}
+
+// icalEvent converts an event into an iCalendar event, timed in the
+// event's time zone.
+func (a *App) icalEvent(r *http.Request, e *db.Event) ical.Event {
+	zone := a.eventZone(e.TimeZone)
+	return ical.Event{
+		UID:          fmt.Sprintf("event-%d@%s", e.ID, a.domain),
+		Sequence:     e.Sequence,
+		Stamp:        e.UpdatedAt,
+		LastModified: e.UpdatedAt,
+		Start:        e.Start.In(zone),
+		End:          e.End.In(zone),
+		Summary:      e.Title,
+		Description:  e.Description,
+		Location:     e.Location,
+		URL:          fmt.Sprintf("%s/events/%d", baseURL(r), e.ID),
+	}
+}
+
+// writeCalendar writes cal as the response body.
+func (a *App) writeCalendar(w http.ResponseWriter, cal *ical.Calendar) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	if err := cal.Encode(w); err != nil {
+		logrus.WithError(err).Error("Failed to encode calendar")
+	}
+}
+
+// newFeedKey generates a random secret key for calendar feed URLs.
+func newFeedKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// canonicalURL returns the https URL of path on the app's domain, for
+// identifiers that must not depend on the host a request was made to.
+func (a *App) canonicalURL(path string) string {
+	return "https://" + a.domain + path
+}
+
+// baseURL returns the scheme and host the request was made to.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
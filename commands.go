@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/importer"
+)
+
+// migrationsDir is where the migrations are read from by default.
+const migrationsDir = "sql/migrations"
+
+// migrationSettings returns the settings migrations read with
+// current_setting, given the app's default time zone.
+func migrationSettings(zone *time.Location) map[string]string {
+	return map[string]string{"protestpulse.time_zone": zone.String()}
+}
+
+// runCommand runs the command line subcommand named by args[0] instead of
+// the web server.
+func (a *App) runCommand(args []string) error {
+	switch args[0] {
+	case "import":
+		return a.importCommand(args[1:])
+	default:
+		return fmt.Errorf("Unknown command %q", args[0])
+	}
+}
+
+// importCommand implements 'protestpulse import [flags] FILE', which
+// imports the events of a CSV or iCalendar file.
+func (a *App) importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	creator := flags.String("creator", "", "id of the user to own the imported events (required)")
+	dryRun := flags.Bool("dry-run", false, "validate and preview the events without importing them")
+	defaultTopic := flags.String("topic", "", "topic name for rows that do not name one")
+	defaultType := flags.String("type", "", "type name for rows that do not name one")
+	zoneName := flags.String("time-zone", "", "IANA time zone the events take place in, by default the app's time_zone")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: protestpulse import [flags] FILE")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("Expected exactly one file to import")
+	}
+	if *creator == "" && !*dryRun {
+		return fmt.Errorf("The -creator flag is required unless -dry-run is set")
+	}
+	if *creator != "" {
+		if _, err := a.store.GetUser(*creator); err == sql.ErrNoRows {
+			return fmt.Errorf("There is no user with id %q; users are created when they first log in", *creator)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	filename := flags.Arg(0)
+	format, err := importer.FormatOf(filename)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zone := a.location
+	if *zoneName != "" {
+		if zone, err = loadZone(*zoneName); err != nil {
+			return err
+		}
+	}
+
+	topics, types, err := a.lookups()
+	if err != nil {
+		return err
+	}
+	rows, err := importer.Parse(f, format, a.importOptions(zone, topics, types, *defaultTopic, *defaultType))
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tTITLE\tSTART\tEND\tTOPIC\tTYPE\tPROBLEMS")
+	for _, row := range rows {
+		problems := "-"
+		if !row.Valid() {
+			invalid++
+			problems = fmt.Sprint(row.Errors)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			row.Line, row.Title, row.Start.Format(dateTimeFormat+" MST"), row.End.Format(dateTimeFormat+" MST"),
+			row.Topic, row.Type, problems)
+	}
+	tw.Flush()
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d rows are invalid, nothing was imported", invalid, len(rows))
+	}
+	if *dryRun {
+		fmt.Printf("%d rows are valid, run without -dry-run to import them\n", len(rows))
+		return nil
+	}
+	if err = a.importRows(*creator, zone, rows); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d events\n", len(rows))
+	return nil
+}
+
+// migrateCommand implements 'protestpulse migrate up|down|status|baseline
+// [flags]', which applies, reverts or lists the migrations of database, or
+// marks them as applied. Migrations that convert times read them in zone,
+// the app's time_zone setting. It only needs the database, so that
+// migrating does not depend on the rest of the configuration or on the
+// tables it creates.
+func migrateCommand(database *db.Database, zone *time.Location, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dir := flags.String("dir", migrationsDir, "directory of the migration files")
+	to := flags.Int("to", 0, "with up, the last version to apply instead of all")
+	steps := flags.Int("steps", 1, "with down, the number of migrations to revert")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: protestpulse migrate up|down|status|baseline [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("Expected one of up, down, status or baseline")
+	}
+	// Flags may also follow the subcommand.
+	command := flags.Arg(0)
+	if err := flags.Parse(flags.Args()[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("Unexpected arguments %q", flags.Args())
+	}
+
+	migrations, err := db.LoadMigrations(*dir)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		done, err := database.MigrateUp(migrations, *to, migrationSettings(zone))
+		for _, m := range done {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("The database is up to date")
+		}
+		return err
+	case "down":
+		if *steps < 1 {
+			return fmt.Errorf("The -steps flag must be at least 1")
+		}
+		done, err := database.MigrateDown(migrations, *steps)
+		for _, m := range done {
+			fmt.Printf("Reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("No migrations are applied")
+		}
+		return err
+	case "baseline":
+		done, err := database.Baseline(migrations)
+		for _, m := range done {
+			fmt.Printf("Marked %04d_%s as applied\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("The original schema is already recorded as applied")
+		}
+		return err
+	case "status":
+		states, err := database.MigrationStatus(migrations)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range states {
+			name, applied := s.Name, "pending"
+			if name == "" {
+				name = "(missing migration files)"
+			}
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, name, applied)
+		}
+		return tw.Flush()
+	default:
+		flags.Usage()
+		return fmt.Errorf("Unknown migrate command %q", command)
+	}
+}
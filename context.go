@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/session"
+)
+
+// contextKey is the type of the keys of request-scoped values that the
+// middleware adds to the request context. The App is shared by all
+// requests, so such values must never be kept on it.
+type contextKey int
+
+const (
+	// apiTokenKey is the key of the *db.APIToken used to authenticate the
+	// request, if any.
+	apiTokenKey contextKey = iota
+	// requestIDKey is the key of the id of the request.
+	requestIDKey
+	// userKey is the key of the *requestUser of the logged in user.
+	userKey
+)
+
+// requestIDHeader carries the request id in requests from proxies that
+// already assigned one, and in every response.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID matches the request ids accepted from clients, so that
+// they cannot inject arbitrary text into the logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID is middleware that gives each request an id, taken from the
+// X-Request-ID header if a proxy set one, and echoes it in the response.
+func RequestID(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		var err error
+		if id, err = randomToken(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set(requestIDHeader, id)
+	next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+}
+
+// requestID returns the id of the request, or an empty string if it did not
+// pass through the RequestID middleware.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// requestLog returns a logger that tags entries with the request id.
+func requestLog(r *http.Request) *logrus.Entry {
+	return logrus.WithField("request_id", requestID(r))
+}
+
+// withAPIToken returns a shallow copy of r authenticated by the API token t
+// of its user.
+func withAPIToken(r *http.Request, t *db.APIToken) *http.Request {
+	ctx := context.WithValue(r.Context(), apiTokenKey, t)
+	return withProfile(r.WithContext(ctx), &session.Profile{UserID: t.UserID})
+}
+
+// requestAPIToken returns the API token used to authenticate the request,
+// or nil if there is none.
+func requestAPIToken(r *http.Request) *db.APIToken {
+	t, _ := r.Context().Value(apiTokenKey).(*db.APIToken)
+	return t
+}
+
+// withProfile returns a shallow copy of r carrying the profile of the
+// logged in user.
+func withProfile(r *http.Request, p *session.Profile) *http.Request {
+	ctx := session.NewContext(r.Context(), p)
+	ctx = context.WithValue(ctx, userKey, &requestUser{id: p.UserID})
+	return r.WithContext(ctx)
+}
+
+// requestUser holds the stored user of the logged in user of a request,
+// which is read on first use so that the role and time zone checks of a
+// request share a single lookup.
+type requestUser struct {
+	id   string
+	once sync.Once
+	user *db.User
+	err  error
+}
+
+// user returns the stored user with the id of p, reading it at most once
+// per request when p is the logged in user of r.
+func (a *App) user(r *http.Request, p *session.Profile) (*db.User, error) {
+	ru, ok := r.Context().Value(userKey).(*requestUser)
+	if !ok || ru.id != p.UserID {
+		return a.store.GetUser(p.UserID)
+	}
+	ru.once.Do(func() {
+		ru.user, ru.err = a.store.GetUser(ru.id)
+	})
+	return ru.user, ru.err
+}
+
+// requestProfile returns the profile of the logged in user, as found by the
+// IsAuthenticated middleware, or nil for anonymous visitors. Handlers read
+// the profile only from here, so that the session is read once per request.
+func requestProfile(r *http.Request) *session.Profile {
+	p, _ := session.FromContext(r.Context())
+	return p
+}
+
+// loggedIn reports whether the request was made by a logged in user, as
+// found by the IsAuthenticated middleware.
+func loggedIn(r *http.Request) bool {
+	return requestProfile(r) != nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/session"
+)
+
+// TestLoginStatePerRequest serves logged in and anonymous requests
+// concurrently and checks that each sees its own login state in the navbar.
+// Run it with -race to also catch request data kept on the shared App.
+func TestLoginStatePerRequest(t *testing.T) {
+	a := newTestApp()
+	h := a.middleware(a.router())
+	cookies := sessionCookies(t, a, map[string]interface{}{
+		"profile": &session.Profile{UserID: "user"},
+	})
+
+	const requests = 50
+	var wg sync.WaitGroup
+	errs := make(chan string, 2*requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(loggedIn bool) {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/auth/login", nil)
+			if loggedIn {
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != http.StatusOK {
+				errs <- fmt.Sprintf("status = %d", rec.Code)
+				return
+			}
+
+			body := rec.Body.String()
+			showsLogout := strings.Contains(body, `href="/auth/logout"`)
+			if showsLogout != loggedIn {
+				errs <- fmt.Sprintf("navbar of a request with loggedIn = %v shows the wrong login state", loggedIn)
+			}
+			if rec.Header().Get(requestIDHeader) == "" {
+				errs <- "response has no request id"
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"bad id\nwith newline", false},
+		{strings.Repeat("a", 65), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set(requestIDHeader, tt.header)
+		}
+		var got string
+		rec := httptest.NewRecorder()
+		RequestID(rec, r, func(w http.ResponseWriter, r *http.Request) {
+			got = requestID(r)
+		})
+		if got == "" || got != rec.Header().Get(requestIDHeader) {
+			t.Errorf("header %q: request id %q, response header %q", tt.header, got, rec.Header().Get(requestIDHeader))
+		}
+		if (got == tt.header) != tt.keep {
+			t.Errorf("header %q: request id %q, want kept = %v", tt.header, got, tt.keep)
+		}
+	}
+}
+
+// countingStore counts the users read from its Store.
+type countingStore struct {
+	db.Store
+	mu       sync.Mutex
+	getUsers int
+}
+
+func (s *countingStore) GetUser(id string) (*db.User, error) {
+	s.mu.Lock()
+	s.getUsers++
+	s.mu.Unlock()
+	return s.Store.GetUser(id)
+}
+
+func TestUserReadOncePerRequest(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	organizer := ts.login("organizer", db.RoleOrganizer)
+	e := ts.createEvent("organizer", "Counted event")
+
+	// The event page checks the role and time zone of the viewer several
+	// times, and reads the event's creator.
+	store := &countingStore{Store: ts.app.store}
+	ts.app.store = store
+	if rec := ts.get(fmt.Sprintf("/events/%d", e.ID), organizer); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if store.getUsers != 2 {
+		t.Errorf("users read = %d, want 2: the viewer once and the creator", store.getUsers)
+	}
+}
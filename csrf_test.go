@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/session"
+)
+
+// serveCSRF runs r through CSRFProtect and reports whether the next handler
+// was called.
+func serveCSRF(a *App, r *http.Request) (*httptest.ResponseRecorder, bool) {
+	called := false
+	rec := httptest.NewRecorder()
+	a.CSRFProtect(rec, r, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	return rec, called
+}
+
+func TestCSRFProtect(t *testing.T) {
+	a := newTestApp()
+	cookies := sessionCookies(t, a, map[string]interface{}{csrfTokenKey: "secret"})
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		token   string
+		header  bool
+		cookies bool
+		allowed bool
+	}{
+		{"GET without token", "GET", "/events", "", false, true, true},
+		{"HEAD without token", "HEAD", "/events", "", false, true, true},
+		{"create without token", "POST", "/events", "", false, true, false},
+		{"create with wrong token", "POST", "/events", "wrong", false, true, false},
+		{"create with token", "POST", "/events", "secret", false, true, true},
+		{"edit with token", "POST", "/events/1", "secret", false, true, true},
+		{"edit without token", "POST", "/events/1", "", false, true, false},
+		{"delete without token", "POST", "/events/1/delete", "", false, true, false},
+		{"delete with token", "POST", "/events/1/delete", "secret", false, true, true},
+		{"attend without token", "POST", "/events/1/attend", "", false, true, false},
+		{"attend with token", "POST", "/events/1/attend", "secret", false, true, true},
+		{"unattend without token", "POST", "/events/1/unattend", "", false, true, false},
+		{"PUT with header token", "PUT", "/events/1", "secret", true, true, true},
+		{"DELETE without token", "DELETE", "/events/1", "", false, true, false},
+		{"token without session", "POST", "/events", "secret", false, false, false},
+		{"empty token without session", "POST", "/events", "", false, false, false},
+	}
+	for _, tt := range tests {
+		form := url.Values{}
+		if tt.token != "" && !tt.header {
+			form.Set(csrfFormField, tt.token)
+		}
+		r := formRequest(tt.method, tt.path, form)
+		if tt.header {
+			r.Header.Set(csrfHeader, tt.token)
+		}
+		if tt.cookies {
+			for _, c := range cookies {
+				r.AddCookie(c)
+			}
+		}
+
+		rec, called := serveCSRF(a, r)
+		if called != tt.allowed {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, called, tt.allowed)
+		}
+		if !tt.allowed && rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestCSRFProtectAPI(t *testing.T) {
+	a := newTestApp()
+
+	r := httptest.NewRequest("POST", "/api/v1/events", strings.NewReader("{}"))
+	r.Header.Set("Content-Type", "application/json")
+	rec, called := serveCSRF(a, r)
+	if called {
+		t.Fatal("API request without a CSRF token was allowed")
+	}
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q, want JSON", ct)
+	}
+}
+
+func TestCSRFProtectTokenRequest(t *testing.T) {
+	a := newTestApp()
+
+	r := httptest.NewRequest("POST", "/api/v1/events", strings.NewReader("{}"))
+	r = withAPIToken(r, &db.APIToken{UserID: "user"})
+	if _, called := serveCSRF(a, r); !called {
+		t.Error("request authenticated with an API token was rejected")
+	}
+}
+
+func TestRenderTemplateCSRFToken(t *testing.T) {
+	a := newTestApp()
+
+	// Visitors who are not logged in get the token in a cookie of its own,
+	// without a session.
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/events", nil)
+	a.renderTemplate(rec, r, "login.tmpl", map[string]interface{}{"Page": "Login"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	var token string
+	for _, c := range rec.Result().Cookies() {
+		switch c.Name {
+		case csrfCookie:
+			token = c.Value
+		case "auth-session":
+			t.Error("an auth-session was saved for an anonymous visitor")
+		}
+	}
+	if token == "" || !strings.Contains(rec.Body.String(), token) {
+		t.Fatal("no CSRF token set in a cookie and rendered in the page")
+	}
+
+	// The cookie token is accepted on the next form post.
+	form := url.Values{csrfFormField: {token}}
+	post := formRequest("POST", "/events", form)
+	for _, c := range rec.Result().Cookies() {
+		post.AddCookie(c)
+	}
+	if _, called := serveCSRF(a, post); !called {
+		t.Error("form post with the rendered CSRF token was rejected")
+	}
+
+	// Logged in users keep the token in their auth-session.
+	rec = httptest.NewRecorder()
+	r = withProfile(httptest.NewRequest("GET", "/events", nil), &session.Profile{UserID: "user"})
+	a.renderTemplate(rec, r, "login.tmpl", map[string]interface{}{"Page": "Login"})
+	if token, _ := sessionValue(t, a, rec, csrfTokenKey).(string); token == "" {
+		t.Fatal("no CSRF token stored in the auth-session of a logged in user")
+	}
+}
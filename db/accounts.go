@@ -0,0 +1,159 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LocalAccount is a first-party account that logs in with an email address
+// and password instead of an identity provider.
+type LocalAccount struct {
+	ID           int
+	Email        string
+	PasswordHash string
+	GivenName    string
+	FamilyName   string
+	FailedLogins int
+	LockedUntil  *time.Time
+	CreatedAt    time.Time
+}
+
+// UserID returns the id the account's user has across the app, which is
+// kept apart from the ids given by identity providers by its prefix.
+func (a *LocalAccount) UserID() string {
+	return fmt.Sprintf("local|%d", a.ID)
+}
+
+// Locked reports whether logins to the account are refused at time t.
+func (a *LocalAccount) Locked(t time.Time) bool {
+	return a.LockedUntil != nil && t.Before(*a.LockedUntil)
+}
+
+// ErrEmailTaken is returned when creating an account with an email address
+// that already has one.
+var ErrEmailTaken = errors.New("An account with this email address already exists")
+
+// localAccountColumns are the columns scanned by scanLocalAccount.
+const localAccountColumns = `id, email, password_hash, given_name, family_name,
+				failed_logins, locked_until, created_at`
+
+// CreateLocalAccount stores a new account, setting its ID and CreatedAt. It
+// returns ErrEmailTaken if another account has the same email address.
+func (db *Database) CreateLocalAccount(a *LocalAccount) error {
+	query := `INSERT INTO local_account (email, password_hash, given_name, family_name)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at`
+	err := db.QueryRow(query, a.Email, a.PasswordHash, a.GivenName, a.FamilyName).Scan(&a.ID, &a.CreatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// LocalAccountByEmail returns the account with the given email address, or
+// sql.ErrNoRows if there is none.
+func (db *Database) LocalAccountByEmail(email string) (*LocalAccount, error) {
+	query := `SELECT ` + localAccountColumns + `
+			FROM local_account
+			WHERE email = $1`
+	return scanLocalAccount(db.QueryRow(query, email))
+}
+
+// LocalAccount returns the account with the given id, or sql.ErrNoRows if
+// there is none.
+func (db *Database) LocalAccount(id int) (*LocalAccount, error) {
+	query := `SELECT ` + localAccountColumns + `
+			FROM local_account
+			WHERE id = $1`
+	return scanLocalAccount(db.QueryRow(query, id))
+}
+
+// RecordFailedLogin counts a failed login to the account. Once maxFailures
+// logins in a row have failed, the account is locked for lockout and the
+// count starts over. It returns the updated account.
+func (db *Database) RecordFailedLogin(id, maxFailures int, lockout time.Duration) (*LocalAccount, error) {
+	query := `UPDATE local_account
+			SET failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+				locked_until = CASE WHEN failed_logins + 1 >= $2
+					THEN now() + $3 * interval '1 second' ELSE locked_until END
+			WHERE id = $1
+			RETURNING ` + localAccountColumns
+	return scanLocalAccount(db.QueryRow(query, id, maxFailures, int(lockout.Seconds())))
+}
+
+// ResetFailedLogins clears the failed login count of the account after a
+// successful login.
+func (db *Database) ResetFailedLogins(id int) error {
+	_, err := db.Exec(`UPDATE local_account
+			SET failed_logins = 0, locked_until = NULL
+			WHERE id = $1`, id)
+	return err
+}
+
+// CreatePasswordReset stores a password reset token for the account under
+// the given hash. The token can be used until expires.
+func (db *Database) CreatePasswordReset(accountID int, hash string, expires time.Time) error {
+	_, err := db.Exec(`INSERT INTO password_reset (account_id, token_hash, expires_at)
+			VALUES ($1, $2, $3)`, accountID, hash, expires)
+	return err
+}
+
+// PasswordResetAccount returns the account of the unused, unexpired reset
+// token stored under hash, or sql.ErrNoRows if there is none.
+func (db *Database) PasswordResetAccount(hash string) (*LocalAccount, error) {
+	query := `SELECT ` + localAccountColumns + `
+			FROM local_account
+			WHERE id = (
+				SELECT account_id FROM password_reset
+				WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+			)`
+	return scanLocalAccount(db.QueryRow(query, hash))
+}
+
+// ResetPassword sets the password hash of the account of the reset token
+// stored under hash, and uses up all of the account's reset tokens. It also
+// unlocks the account. It returns sql.ErrNoRows if the token cannot be used.
+func (db *Database) ResetPassword(hash, passwordHash string) (*LocalAccount, error) {
+	var a *LocalAccount
+	err := db.inTx(func(tx *sql.Tx) error {
+		var accountID int
+		err := tx.QueryRow(`UPDATE password_reset
+				SET used_at = now()
+				WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+				RETURNING account_id`, hash).Scan(&accountID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE password_reset
+				SET used_at = now()
+				WHERE account_id = $1 AND used_at IS NULL`, accountID)
+		if err != nil {
+			return err
+		}
+		a, err = scanLocalAccount(tx.QueryRow(`UPDATE local_account
+				SET password_hash = $2, failed_logins = 0, locked_until = NULL
+				WHERE id = $1
+				RETURNING `+localAccountColumns, accountID, passwordHash))
+		return err
+	})
+	return a, err
+}
+
+// scanLocalAccount scans the columns in localAccountColumns.
+func scanLocalAccount(row scanner) (*LocalAccount, error) {
+	a := &LocalAccount{}
+	var lockedUntil pq.NullTime
+	err := row.Scan(&a.ID, &a.Email, &a.PasswordHash, &a.GivenName, &a.FamilyName,
+		&a.FailedLogins, &lockedUntil, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		a.LockedUntil = &lockedUntil.Time
+	}
+	return a, nil
+}
@@ -0,0 +1,31 @@
+package db
+
+// CalendarFeedKey returns the secret key of the user's calendar feeds,
+// storing newKey as the key if the user does not have one yet.
+func (db *Database) CalendarFeedKey(userID, newKey string) (string, error) {
+	var key string
+	query := `INSERT INTO calendar_feed (user_id, feed_key)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+			RETURNING feed_key`
+	err := db.QueryRow(query, userID, newKey).Scan(&key)
+	return key, err
+}
+
+// ResetCalendarFeedKey replaces the secret key of the user's calendar feeds,
+// so that previously shared feed URLs stop working.
+func (db *Database) ResetCalendarFeedKey(userID, newKey string) error {
+	query := `INSERT INTO calendar_feed (user_id, feed_key)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET feed_key = EXCLUDED.feed_key`
+	_, err := db.Exec(query, userID, newKey)
+	return err
+}
+
+// CalendarFeedUser returns the id of the user whose calendar feeds use key,
+// or sql.ErrNoRows if there is none.
+func (db *Database) CalendarFeedUser(key string) (string, error) {
+	var userID string
+	err := db.QueryRow(`SELECT user_id FROM calendar_feed WHERE feed_key = $1`, key).Scan(&userID)
+	return userID, err
+}
@@ -48,8 +48,21 @@ func New(c Config) *Database {
 	return &Database{ppdb}
 }
 
-// GetMyEvents returns the events that the user has marked.
-func (db *Database) GetMyEvents(email string) (int, error) {
-	//TODO
-	return 0, nil
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// inTx runs fn inside a transaction, committing if fn succeeds and rolling
+// back otherwise.
+func (db *Database) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
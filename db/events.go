@@ -0,0 +1,438 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Maximum lengths of the text fields of an event, in characters.
+const (
+	MaxTitleLength       = 200
+	MaxLocationLength    = 200
+	MaxDescriptionLength = 5000
+)
+
+// EventSummary is the minimal set of event fields needed to list an event.
+type EventSummary struct {
+	ID    int       `json:"id"`
+	Title string    `json:"title"`
+	Start time.Time `json:"start"`
+	// TimeZone is the IANA name of the time zone the event takes place in.
+	TimeZone string `json:"time_zone"`
+}
+
+// Event is a single row of the event table.
+type Event struct {
+	ID int `json:"id"`
+	// CreatorID is the id of the user that created the event, a reference to
+	// users.id.
+	CreatorID   string    `json:"creator_id"`
+	Title       string    `json:"title"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Description string    `json:"description"`
+	Type        int       `json:"type"`
+	Topic       int       `json:"topic"`
+	Location    string    `json:"location"`
+	// TimeZone is the IANA name of the time zone the event takes place in.
+	// Start and End are instants, shown in this zone as the event's local
+	// time.
+	TimeZone string `json:"time_zone"`
+	// UserCount is the number of users who have marked the event.
+	UserCount int `json:"user_count"`
+	// Sequence counts the updates made to the event since it was created.
+	Sequence  int       `json:"sequence"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// HiddenAt is set when a moderator has hidden the event. Hidden events
+	// are left out of all lists of events.
+	HiddenAt *time.Time `json:"hidden_at,omitempty"`
+}
+
+// eventColumns are the columns of the event table scanned by scanEvent.
+const eventColumns = `
+				e.id, e.creator_id, e.title, e.start_timestamp, e.end_timestamp,
+				e.time_zone, e.description, e.event_type, e.event_topic,
+				e.location, COALESCE(e.user_count, 0),
+				e.sequence, e.created_at, e.updated_at, e.hidden_at`
+
+// scanEvent scans a row selected with eventColumns.
+func scanEvent(row scanner) (*Event, error) {
+	e := &Event{}
+	var hiddenAt pq.NullTime
+	err := row.Scan(
+		&e.ID, &e.CreatorID, &e.Title, &e.Start, &e.End,
+		&e.TimeZone, &e.Description, &e.Type, &e.Topic,
+		&e.Location, &e.UserCount,
+		&e.Sequence, &e.CreatedAt, &e.UpdatedAt, &hiddenAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if hiddenAt.Valid {
+		e.HiddenAt = &hiddenAt.Time
+	}
+	return e, nil
+}
+
+// queryEvents runs a query selecting eventColumns and collects the rows.
+func (db *Database) queryEvents(query string, args ...interface{}) ([]Event, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// GetEvent returns the event with the given id, or sql.ErrNoRows if there
+// is none. Hidden events are returned too.
+func (db *Database) GetEvent(id int) (*Event, error) {
+	query := `SELECT` + eventColumns + `
+			FROM event e
+			WHERE e.id = $1`
+	return scanEvent(db.QueryRow(query, id))
+}
+
+// EventsCreatedBy returns the events created by the user, ordered by start
+// time. Hidden events are included, since the user can still see them.
+func (db *Database) EventsCreatedBy(userID string) ([]Event, error) {
+	query := `SELECT` + eventColumns + `
+			FROM event e
+			WHERE e.creator_id = $1
+			ORDER BY e.start_timestamp, e.id`
+	return db.queryEvents(query, userID)
+}
+
+// EventsAttending returns the events the user has marked, ordered by start time.
+func (db *Database) EventsAttending(userID string) ([]Event, error) {
+	query := `SELECT` + eventColumns + `
+			FROM event e
+			JOIN user_events ue ON ue.event_id = e.id
+			WHERE ue.user_id = $1 AND e.hidden_at IS NULL
+			ORDER BY e.start_timestamp, e.id`
+	return db.queryEvents(query, userID)
+}
+
+// EventsInUserTopics returns the events starting at or after since whose
+// topic the user follows, ordered by start time.
+func (db *Database) EventsInUserTopics(userID string, since time.Time) ([]Event, error) {
+	query := `SELECT` + eventColumns + `
+			FROM event e
+			JOIN user_event_topics ut ON ut.topic_id = e.event_topic
+			WHERE ut.user_id = $1 AND e.start_timestamp >= $2 AND e.hidden_at IS NULL
+			ORDER BY e.start_timestamp, e.id`
+	return db.queryEvents(query, userID, since)
+}
+
+// RecentEvents returns up to limit of the most recently created events,
+// newest first. If topic or typ are non-zero, only events with that topic or
+// type are included.
+func (db *Database) RecentEvents(topic, typ, limit int) ([]Event, error) {
+	query := `SELECT` + eventColumns + `
+			FROM event e
+			WHERE ($1 = 0 OR e.event_topic = $1)
+			  AND ($2 = 0 OR e.event_type = $2)
+			  AND e.hidden_at IS NULL
+			ORDER BY e.created_at DESC, e.id DESC
+			LIMIT $3`
+	return db.queryEvents(query, topic, typ, limit)
+}
+
+// CreateEvent inserts e and sets its ID. The user count of a new event is
+// always zero.
+func (db *Database) CreateEvent(e *Event) error {
+	return createEvent(db, e)
+}
+
+// CreateEvents inserts all of events in a single transaction, so that
+// either all or none of them are created.
+func (db *Database) CreateEvents(events []*Event) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		for _, e := range events {
+			if err := createEvent(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rowQuerier is implemented by both *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// createEvent inserts e using q and sets its ID.
+func createEvent(q rowQuerier, e *Event) error {
+	query := `INSERT INTO event (
+				creator_id, title, start_timestamp,
+				end_timestamp, time_zone, description,
+				event_topic, event_type, location,
+				user_count
+			)
+			VALUES (
+				$1, $2, $3,
+				$4, $5, $6,
+				$7, $8, $9,
+				0
+			)
+			RETURNING id, sequence, created_at, updated_at`
+	e.UserCount = 0
+	return q.QueryRow(query,
+		e.CreatorID, e.Title, e.Start,
+		e.End, e.TimeZone, e.Description,
+		e.Topic, e.Type, e.Location,
+	).Scan(&e.ID, &e.Sequence, &e.CreatedAt, &e.UpdatedAt)
+}
+
+// UpdateEvent saves the editable fields of e, increments its sequence and
+// sets its update time. The creator and user count are left unchanged.
+func (db *Database) UpdateEvent(e *Event) error {
+	query := `UPDATE event SET
+				title = $2, start_timestamp = $3, end_timestamp = $4,
+				description = $5, event_type = $6, event_topic = $7,
+				location = $8, time_zone = $9,
+				sequence = sequence + 1, updated_at = now()
+			WHERE id = $1
+			RETURNING sequence, updated_at`
+	return db.QueryRow(query,
+		e.ID, e.Title, e.Start, e.End,
+		e.Description, e.Type, e.Topic,
+		e.Location, e.TimeZone,
+	).Scan(&e.Sequence, &e.UpdatedAt)
+}
+
+// HideEvent hides the event with the given id on behalf of the moderator
+// with the id by. It returns sql.ErrNoRows if there is no such event.
+func (db *Database) HideEvent(id int, by string) error {
+	var found int
+	return db.QueryRow(`UPDATE event
+			SET hidden_at = COALESCE(hidden_at, now()), hidden_by = COALESCE(hidden_by, $2)
+			WHERE id = $1
+			RETURNING id`, id, by).Scan(&found)
+}
+
+// UnhideEvent shows the event with the given id again. It returns
+// sql.ErrNoRows if there is no such event.
+func (db *Database) UnhideEvent(id int) error {
+	var found int
+	return db.QueryRow(`UPDATE event
+			SET hidden_at = NULL, hidden_by = NULL
+			WHERE id = $1
+			RETURNING id`, id).Scan(&found)
+}
+
+// DeleteEvent deletes the event with the given id, along with the marks
+// users have placed on it.
+func (db *Database) DeleteEvent(id int) error {
+	_, err := db.Exec(`DELETE FROM event WHERE id = $1`, id)
+	return err
+}
+
+// Cursor marks a position in a list of events ordered by start time and id,
+// and is used for keyset pagination.
+type Cursor struct {
+	Start time.Time
+	ID    int
+}
+
+// cursorFormat is the timestamp layout used when encoding a Cursor.
+const cursorFormat = "20060102T150405.999999"
+
+// String encodes the cursor for use in a URL. The start time is encoded in
+// UTC, which ParseCursor assumes.
+func (c Cursor) String() string {
+	return fmt.Sprintf("%s_%d", c.Start.UTC().Format(cursorFormat), c.ID)
+}
+
+// ParseCursor decodes a cursor previously encoded with Cursor.String.
+func ParseCursor(s string) (*Cursor, error) {
+	parts := strings.SplitN(s, "_", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid cursor %q", s)
+	}
+	start, err := time.Parse(cursorFormat, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor %q: %v", s, err)
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor %q: %v", s, err)
+	}
+	return &Cursor{Start: start, ID: id}, nil
+}
+
+// EventFilter narrows down a list of events. Zero values are ignored.
+type EventFilter struct {
+	Topic    int
+	Type     int
+	Location string
+	// Creator, if set, selects the events created by the user with this id.
+	Creator string
+	// From and To bound the event start time. If From is zero, only events
+	// that have not started yet are included.
+	From time.Time
+	To   time.Time
+	// After selects the page of events following the given position.
+	After *Cursor
+	Limit int
+}
+
+// ListEvents returns the events matching f, ordered by start time. The
+// returned cursor is non-nil if more events remain.
+func (db *Database) ListEvents(f EventFilter) ([]EventSummary, *Cursor, error) {
+	where := []string{"hidden_at IS NULL"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.From.IsZero() {
+		where = append(where, "start_timestamp >= now()")
+	} else {
+		where = append(where, "start_timestamp >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "start_timestamp < "+arg(f.To))
+	}
+	if f.Topic != 0 {
+		where = append(where, "event_topic = "+arg(f.Topic))
+	}
+	if f.Type != 0 {
+		where = append(where, "event_type = "+arg(f.Type))
+	}
+	if f.Location != "" {
+		// strpos matches the text literally, unlike ILIKE, which would treat
+		// '%', '_' and '\' in it as patterns.
+		where = append(where, "strpos(lower(location), lower("+arg(f.Location)+")) > 0")
+	}
+	if f.Creator != "" {
+		where = append(where, "creator_id = "+arg(f.Creator))
+	}
+	if f.After != nil {
+		where = append(where, fmt.Sprintf("(start_timestamp, id) > (%s, %s)", arg(f.After.Start), arg(f.After.ID)))
+	}
+
+	query := `SELECT
+				id, title, start_timestamp, time_zone
+			FROM event
+			WHERE ` + strings.Join(where, " AND ") + `
+			ORDER BY start_timestamp, id
+			LIMIT ` + arg(f.Limit+1)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	events := []EventSummary{}
+	for rows.Next() {
+		var e EventSummary
+		if err := rows.Scan(&e.ID, &e.Title, &e.Start, &e.TimeZone); err != nil {
+			return nil, nil, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var next *Cursor
+	if len(events) > f.Limit {
+		events = events[:f.Limit]
+		last := events[f.Limit-1]
+		next = &Cursor{Start: last.Start, ID: last.ID}
+	}
+	return events, next, nil
+}
+
+// GetMyEvents returns the events that the user has marked, ordered by start time.
+func (db *Database) GetMyEvents(userID string) ([]EventSummary, error) {
+	query := `SELECT
+				e.id, e.title, e.start_timestamp, e.time_zone
+			FROM event e
+			JOIN user_events ue ON ue.event_id = e.id
+			WHERE ue.user_id = $1 AND e.hidden_at IS NULL
+			ORDER BY e.start_timestamp, e.id`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []EventSummary{}
+	for rows.Next() {
+		var e EventSummary
+		if err := rows.Scan(&e.ID, &e.Title, &e.Start, &e.TimeZone); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// IsAttending reports whether the user has marked the given event.
+func (db *Database) IsAttending(userID string, eventID int) (bool, error) {
+	var attending bool
+	query := `SELECT EXISTS (
+				SELECT 1 FROM user_events WHERE user_id = $1 AND event_id = $2
+			)`
+	err := db.QueryRow(query, userID, eventID).Scan(&attending)
+	return attending, err
+}
+
+// Attend marks the event for the user and increments the event's cached
+// user_count. Marking an event twice has no effect.
+func (db *Database) Attend(userID string, eventID int) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`INSERT INTO user_events (user_id, event_id)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING`, userID, eventID)
+		if err != nil {
+			return err
+		}
+		return updateUserCount(tx, res, eventID, 1)
+	})
+}
+
+// Unattend unmarks the event for the user and decrements the event's cached
+// user_count. Unmarking an event that was not marked has no effect.
+func (db *Database) Unattend(userID string, eventID int) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM user_events
+				WHERE user_id = $1 AND event_id = $2`, userID, eventID)
+		if err != nil {
+			return err
+		}
+		return updateUserCount(tx, res, eventID, -1)
+	})
+}
+
+// updateUserCount adjusts the cached user_count of an event by delta if
+// res changed a user_events row.
+func updateUserCount(tx *sql.Tx, res sql.Result, eventID int, delta int) error {
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE event
+			SET user_count = COALESCE(user_count, 0) + $2
+			WHERE id = $1`, eventID, delta)
+	return err
+}
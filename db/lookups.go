@@ -0,0 +1,36 @@
+package db
+
+// Lookup is a row of one of the event_topic or event_type lookup tables.
+type Lookup struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// EventTopics returns all rows of the event_topic table ordered by id.
+func (db *Database) EventTopics() ([]Lookup, error) {
+	return db.lookups(`SELECT id, name FROM event_topic ORDER BY id`)
+}
+
+// EventTypes returns all rows of the event_type table ordered by id.
+func (db *Database) EventTypes() ([]Lookup, error) {
+	return db.lookups(`SELECT id, name FROM event_type ORDER BY id`)
+}
+
+// lookups runs a query selecting id and name columns and collects the rows.
+func (db *Database) lookups(query string) ([]Lookup, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lookups := []Lookup{}
+	for rows.Next() {
+		var l Lookup
+		if err := rows.Scan(&l.ID, &l.Name); err != nil {
+			return nil, err
+		}
+		lookups = append(lookups, l)
+	}
+	return lookups, rows.Err()
+}
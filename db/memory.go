@@ -0,0 +1,676 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store keeping everything in memory, for tests that
+// should not need a database. It starts out with the event topics and
+// types of the initial migration and enforces the same references between
+// users and events as the schema.
+type MemoryStore struct {
+	mu     sync.Mutex
+	users  map[string]User
+	events map[int]Event
+	// hiddenBy holds who hid each hidden event.
+	hiddenBy map[int]string
+	// attending holds the ids of the users who marked each event.
+	attending  map[int]map[string]bool
+	userTopics map[string][]int
+	userTypes  map[string][]int
+	topics     []Lookup
+	types      []Lookup
+	lastID     int
+	tokens     []memoryToken
+	// feedKeys holds the calendar feed key of each user.
+	feedKeys map[string]string
+	accounts map[int]LocalAccount
+	resets   []memoryReset
+	// now returns the current time. Tests may replace it.
+	now func() time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:      map[string]User{},
+		events:     map[int]Event{},
+		hiddenBy:   map[int]string{},
+		attending:  map[int]map[string]bool{},
+		userTopics: map[string][]int{},
+		userTypes:  map[string][]int{},
+		feedKeys:   map[string]string{},
+		accounts:   map[int]LocalAccount{},
+		topics: lookupList(
+			"police violence", "environment", "gender equality", "racial justice",
+			"lgbtq rights", "indigenous rights", "animal rights", "other",
+		),
+		types: lookupList("in person", "online", "donation"),
+		now:   time.Now,
+	}
+}
+
+// lookupList numbers names from 1, like the rows of a lookup table.
+func lookupList(names ...string) []Lookup {
+	lookups := []Lookup{}
+	for i, name := range names {
+		lookups = append(lookups, Lookup{ID: i + 1, Name: name})
+	}
+	return lookups
+}
+
+// SaveLogin records a login of the user, like Database.SaveLogin.
+func (m *MemoryStore) SaveLogin(u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if old, ok := m.users[u.ID]; ok {
+		u.Role = old.Role
+		u.TimeZone = old.TimeZone
+		u.CreatedAt = old.CreatedAt
+	} else {
+		u.Role = RoleMember
+		u.CreatedAt = now
+	}
+	u.LastLoginAt = now
+	m.users[u.ID] = *u
+	return nil
+}
+
+// GetUser returns the user with the given id, or sql.ErrNoRows if there is
+// none.
+func (m *MemoryStore) GetUser(id string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &u, nil
+}
+
+// ListUsers returns up to limit users whose display name, email address or
+// id contain search, ordered by display name.
+func (m *MemoryStore) ListUsers(search string, limit int) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := []User{}
+	for _, u := range m.users {
+		if search == "" || containsFold(u.DisplayName, search) || containsFold(u.Email, search) || containsFold(u.ID, search) {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].DisplayName != users[j].DisplayName {
+			return users[i].DisplayName < users[j].DisplayName
+		}
+		return users[i].ID < users[j].ID
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// SetUserRole changes the role of the user with the given id. It returns
+// sql.ErrNoRows if there is no such user.
+func (m *MemoryStore) SetUserRole(id string, role Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	u.Role = role
+	m.users[id] = u
+	return nil
+}
+
+// SetUserTimeZone changes the time zone the user with the given id sees
+// times in. It returns sql.ErrNoRows if there is no such user.
+func (m *MemoryStore) SetUserTimeZone(id, zone string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	u.TimeZone = zone
+	m.users[id] = u
+	return nil
+}
+
+// GetEvent returns the event with the given id, or sql.ErrNoRows if there
+// is none. Hidden events are returned too.
+func (m *MemoryStore) GetEvent(id int) (*Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.events[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &e, nil
+}
+
+// ListEvents returns the events matching f, ordered by start time, like
+// Database.ListEvents.
+func (m *MemoryStore) ListEvents(f EventFilter) ([]EventSummary, *Cursor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := f.From
+	if from.IsZero() {
+		from = m.now()
+	}
+	matches := m.filterEvents(func(e Event) bool {
+		switch {
+		case e.Start.Before(from):
+			return false
+		case !f.To.IsZero() && !e.Start.Before(f.To):
+			return false
+		case f.Topic != 0 && e.Topic != f.Topic:
+			return false
+		case f.Type != 0 && e.Type != f.Type:
+			return false
+		case f.Location != "" && !containsFold(e.Location, f.Location):
+			return false
+		case f.Creator != "" && e.CreatorID != f.Creator:
+			return false
+		case f.After != nil && !startsAfter(e, f.After.Start, f.After.ID):
+			return false
+		}
+		return true
+	})
+
+	events := summaries(matches)
+	var next *Cursor
+	if len(events) > f.Limit {
+		events = events[:f.Limit]
+		last := events[f.Limit-1]
+		next = &Cursor{Start: last.Start, ID: last.ID}
+	}
+	return events, next, nil
+}
+
+// SearchEvents returns up to limit events whose title, description or
+// location contain all of the words in q, best matches first. Unlike
+// Database.SearchEvents it matches words as plain substrings, without
+// stemming.
+func (m *MemoryStore) SearchEvents(q string, limit int) ([]SearchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	words := strings.Fields(strings.ToLower(q))
+	results := []SearchResult{}
+	if len(words) == 0 {
+		return results, nil
+	}
+	for _, e := range m.filterEvents(func(Event) bool { return true }) {
+		text := strings.ToLower(e.Title + " " + e.Description + " " + e.Location)
+		res := SearchResult{EventSummary: EventSummary{ID: e.ID, Title: e.Title, Start: e.Start, TimeZone: e.TimeZone}}
+		for _, w := range words {
+			n := strings.Count(text, w)
+			if n == 0 {
+				res.Rank = 0
+				break
+			}
+			res.Rank += float64(n)
+		}
+		if res.Rank == 0 {
+			continue
+		}
+		res.TitleHTML = highlight(markWords(e.Title, words))
+		res.SnippetHTML = highlight(markWords(e.Description+" "+e.Location, words))
+		results = append(results, res)
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank > results[j].Rank })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// markWords wraps the occurrences of words in s with the highlight markers.
+func markWords(s string, words []string) string {
+	lower := strings.ToLower(s)
+	if len(lower) != len(s) {
+		// Lowering s changed where its bytes are, so matches in lower
+		// can't be marked in s.
+		return s
+	}
+	marked := make([]bool, len(s))
+	for _, w := range words {
+		for i := 0; ; {
+			j := strings.Index(lower[i:], w)
+			if j < 0 {
+				break
+			}
+			for k := i + j; k < i+j+len(w); k++ {
+				marked[k] = true
+			}
+			i += j + len(w)
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(highlightStart)
+		}
+		b.WriteByte(s[i])
+		if marked[i] && (i == len(s)-1 || !marked[i+1]) {
+			b.WriteString(highlightStop)
+		}
+	}
+	return b.String()
+}
+
+// RecentEvents returns up to limit of the most recently created events,
+// newest first. If topic or typ are non-zero, only events with that topic or
+// type are included.
+func (m *MemoryStore) RecentEvents(topic, typ, limit int) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := m.filterEvents(func(e Event) bool {
+		return (topic == 0 || e.Topic == topic) && (typ == 0 || e.Type == typ)
+	})
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.After(events[j].CreatedAt)
+		}
+		return events[i].ID > events[j].ID
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// EventsCreatedBy returns the events created by the user, ordered by start
+// time. Hidden events are included.
+func (m *MemoryStore) EventsCreatedBy(userID string) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []Event{}
+	for _, e := range m.events {
+		if e.CreatorID == userID {
+			events = append(events, e)
+		}
+	}
+	sortByStart(events)
+	return events, nil
+}
+
+// EventsInUserTopics returns the events starting at or after since whose
+// topic the user follows, ordered by start time.
+func (m *MemoryStore) EventsInUserTopics(userID string, since time.Time) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	topics := m.userTopics[userID]
+	return m.filterEvents(func(e Event) bool {
+		return !e.Start.Before(since) && containsInt(topics, e.Topic)
+	}), nil
+}
+
+// CreateEvent adds e and sets its ID.
+func (m *MemoryStore) CreateEvent(e *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkEvent(e); err != nil {
+		return err
+	}
+	m.createEvent(e)
+	return nil
+}
+
+// CreateEvents adds all of events, or none of them if one is invalid.
+func (m *MemoryStore) CreateEvents(events []*Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range events {
+		if err := m.checkEvent(e); err != nil {
+			return err
+		}
+	}
+	for _, e := range events {
+		m.createEvent(e)
+	}
+	return nil
+}
+
+// createEvent adds e, which has been checked, and sets its ID.
+func (m *MemoryStore) createEvent(e *Event) {
+	m.lastID++
+	now := m.now()
+	e.ID = m.lastID
+	e.UserCount = 0
+	e.Sequence = 0
+	e.CreatedAt = now
+	e.UpdatedAt = now
+	e.HiddenAt = nil
+	m.events[e.ID] = *e
+}
+
+// checkEvent returns an error if e refers to a user, topic or type that
+// does not exist, as the foreign keys of the event table would.
+func (m *MemoryStore) checkEvent(e *Event) error {
+	if _, ok := m.users[e.CreatorID]; e.CreatorID != "" && !ok {
+		return fmt.Errorf("Event creator %q does not exist", e.CreatorID)
+	}
+	if e.Topic != 0 && !hasLookup(m.topics, e.Topic) {
+		return fmt.Errorf("Event topic %d does not exist", e.Topic)
+	}
+	if e.Type != 0 && !hasLookup(m.types, e.Type) {
+		return fmt.Errorf("Event type %d does not exist", e.Type)
+	}
+	return nil
+}
+
+// UpdateEvent saves the editable fields of e, increments its sequence and
+// sets its update time. It returns sql.ErrNoRows if there is no such event.
+func (m *MemoryStore) UpdateEvent(e *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.events[e.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if err := m.checkEvent(&Event{Topic: e.Topic, Type: e.Type}); err != nil {
+		return err
+	}
+	old.Title = e.Title
+	old.Start = e.Start
+	old.End = e.End
+	old.Description = e.Description
+	old.Type = e.Type
+	old.Topic = e.Topic
+	old.Location = e.Location
+	old.TimeZone = e.TimeZone
+	old.Sequence++
+	old.UpdatedAt = m.now()
+	m.events[e.ID] = old
+
+	e.Sequence = old.Sequence
+	e.UpdatedAt = old.UpdatedAt
+	return nil
+}
+
+// DeleteEvent deletes the event with the given id, along with the marks
+// users have placed on it.
+func (m *MemoryStore) DeleteEvent(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.events, id)
+	delete(m.hiddenBy, id)
+	delete(m.attending, id)
+	return nil
+}
+
+// HideEvent hides the event with the given id on behalf of the moderator
+// with the id by. It returns sql.ErrNoRows if there is no such event.
+func (m *MemoryStore) HideEvent(id int, by string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.events[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if e.HiddenAt == nil {
+		now := m.now()
+		e.HiddenAt = &now
+		m.hiddenBy[id] = by
+		m.events[id] = e
+	}
+	return nil
+}
+
+// UnhideEvent shows the event with the given id again. It returns
+// sql.ErrNoRows if there is no such event.
+func (m *MemoryStore) UnhideEvent(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.events[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	e.HiddenAt = nil
+	delete(m.hiddenBy, id)
+	m.events[id] = e
+	return nil
+}
+
+// IsAttending reports whether the user has marked the given event.
+func (m *MemoryStore) IsAttending(userID string, eventID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.attending[eventID][userID], nil
+}
+
+// Attend marks the event for the user and increments its user count.
+// Marking an event twice has no effect.
+func (m *MemoryStore) Attend(userID string, eventID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.events[eventID]
+	if !ok {
+		return fmt.Errorf("Event %d does not exist", eventID)
+	}
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("User %q does not exist", userID)
+	}
+	if m.attending[eventID] == nil {
+		m.attending[eventID] = map[string]bool{}
+	}
+	if !m.attending[eventID][userID] {
+		m.attending[eventID][userID] = true
+		e.UserCount++
+		m.events[eventID] = e
+	}
+	return nil
+}
+
+// Unattend unmarks the event for the user and decrements its user count.
+// Unmarking an event that was not marked has no effect.
+func (m *MemoryStore) Unattend(userID string, eventID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.attending[eventID][userID] {
+		return nil
+	}
+	delete(m.attending[eventID], userID)
+	e := m.events[eventID]
+	e.UserCount--
+	m.events[eventID] = e
+	return nil
+}
+
+// GetMyEvents returns the events that the user has marked, ordered by start time.
+func (m *MemoryStore) GetMyEvents(userID string) ([]EventSummary, error) {
+	events, err := m.EventsAttending(userID)
+	return summaries(events), err
+}
+
+// EventsAttending returns the events the user has marked, ordered by start time.
+func (m *MemoryStore) EventsAttending(userID string) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filterEvents(func(e Event) bool { return m.attending[e.ID][userID] }), nil
+}
+
+// UserTopics returns the ids of the event topics the user follows.
+func (m *MemoryStore) UserTopics(userID string) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]int{}, m.userTopics[userID]...), nil
+}
+
+// UserTypes returns the ids of the event types the user follows.
+func (m *MemoryStore) UserTypes(userID string) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]int{}, m.userTypes[userID]...), nil
+}
+
+// SetPreferences replaces the event topics and types the user follows.
+// Repeated ids are followed once.
+func (m *MemoryStore) SetPreferences(userID string, topicIDs, typeIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("User %q does not exist", userID)
+	}
+	for _, id := range topicIDs {
+		if !hasLookup(m.topics, id) {
+			return fmt.Errorf("Event topic %d does not exist", id)
+		}
+	}
+	for _, id := range typeIDs {
+		if !hasLookup(m.types, id) {
+			return fmt.Errorf("Event type %d does not exist", id)
+		}
+	}
+	m.userTopics[userID] = sortedIDs(topicIDs)
+	m.userTypes[userID] = sortedIDs(typeIDs)
+	return nil
+}
+
+// RecommendedEvents returns up to limit upcoming events whose topic or type
+// the user follows, ranked like Database.RecommendedEvents.
+func (m *MemoryStore) RecommendedEvents(userID string, limit int) ([]EventSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	score := func(e Event) float64 {
+		s := 0.0
+		if containsInt(m.userTopics[userID], e.Topic) {
+			s += 2
+		}
+		if containsInt(m.userTypes[userID], e.Type) {
+			s++
+		}
+		return s / (1 + e.Start.Sub(now).Seconds()/604800)
+	}
+	events := m.filterEvents(func(e Event) bool {
+		return !e.Start.Before(now) && score(e) > 0
+	})
+	sort.SliceStable(events, func(i, j int) bool { return score(events[i]) > score(events[j]) })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return summaries(events), nil
+}
+
+// EventTopics returns all event topics ordered by id.
+func (m *MemoryStore) EventTopics() ([]Lookup, error) {
+	return append([]Lookup{}, m.topics...), nil
+}
+
+// EventTypes returns all event types ordered by id.
+func (m *MemoryStore) EventTypes() ([]Lookup, error) {
+	return append([]Lookup{}, m.types...), nil
+}
+
+// filterEvents returns the events that are not hidden and match keep,
+// ordered by start time. m.mu must be held.
+func (m *MemoryStore) filterEvents(keep func(e Event) bool) []Event {
+	events := []Event{}
+	for _, e := range m.events {
+		if e.HiddenAt == nil && keep(e) {
+			events = append(events, e)
+		}
+	}
+	sortByStart(events)
+	return events
+}
+
+// sortByStart sorts events by start time and id.
+func sortByStart(events []Event) {
+	sort.Slice(events, func(i, j int) bool {
+		return startsAfter(events[j], events[i].Start, events[i].ID)
+	})
+}
+
+// startsAfter reports whether e comes after the position (start, id) in a
+// list ordered by start time and id.
+func startsAfter(e Event, start time.Time, id int) bool {
+	if !e.Start.Equal(start) {
+		return e.Start.After(start)
+	}
+	return e.ID > id
+}
+
+// summaries returns the summaries of events.
+func summaries(events []Event) []EventSummary {
+	s := []EventSummary{}
+	for _, e := range events {
+		s = append(s, EventSummary{ID: e.ID, Title: e.Title, Start: e.Start, TimeZone: e.TimeZone})
+	}
+	return s
+}
+
+func hasLookup(lookups []Lookup, id int) bool {
+	for _, l := range lookups {
+		if l.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedIDs returns the distinct ids in ascending order.
+func sortedIDs(ids []int) []int {
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+	unique := sorted[:0]
+	for _, id := range sorted {
+		if len(unique) == 0 || unique[len(unique)-1] != id {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// containsFold reports whether substr is within s, ignoring case, like ILIKE.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,279 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// memoryToken is an API token kept by MemoryStore.
+type memoryToken struct {
+	APIToken
+	hash    string
+	revoked bool
+}
+
+// memoryReset is a password reset token kept by MemoryStore.
+type memoryReset struct {
+	accountID int
+	hash      string
+	expires   time.Time
+	used      bool
+}
+
+// CreateAPIToken stores a new token for the user under the given hash.
+func (m *MemoryStore) CreateAPIToken(userID, name string, scopes []string, hash string) (*APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return nil, fmt.Errorf("User %q does not exist", userID)
+	}
+	for _, t := range m.tokens {
+		if t.hash == hash {
+			return nil, fmt.Errorf("A token with this hash already exists")
+		}
+	}
+	t := APIToken{
+		ID:        len(m.tokens) + 1,
+		UserID:    userID,
+		Name:      name,
+		Scopes:    append([]string{}, scopes...),
+		CreatedAt: m.now(),
+	}
+	m.tokens = append(m.tokens, memoryToken{APIToken: t, hash: hash})
+	return &t, nil
+}
+
+// APITokens returns the user's tokens that have not been revoked, newest first.
+func (m *MemoryStore) APITokens(userID string) ([]APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tokens := []APIToken{}
+	for i := len(m.tokens) - 1; i >= 0; i-- {
+		if t := m.tokens[i]; t.UserID == userID && !t.revoked {
+			tokens = append(tokens, t.APIToken)
+		}
+	}
+	return tokens, nil
+}
+
+// UseAPIToken returns the unrevoked token stored under hash and records that
+// it was used, or returns sql.ErrNoRows if there is none.
+func (m *MemoryStore) UseAPIToken(hash string) (*APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.tokens {
+		t := &m.tokens[i]
+		if t.hash == hash && !t.revoked {
+			now := m.now()
+			t.LastUsedAt = &now
+			used := t.APIToken
+			return &used, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// RevokeAPIToken revokes one of the user's tokens. Revoking a token that
+// does not belong to the user has no effect.
+func (m *MemoryStore) RevokeAPIToken(userID string, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.tokens {
+		if t := &m.tokens[i]; t.ID == id && t.UserID == userID {
+			t.revoked = true
+		}
+	}
+	return nil
+}
+
+// CalendarFeedKey returns the secret key of the user's calendar feeds,
+// storing newKey as the key if the user does not have one yet.
+func (m *MemoryStore) CalendarFeedKey(userID, newKey string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.feedKeys[userID]; ok {
+		return key, nil
+	}
+	return newKey, m.setFeedKey(userID, newKey)
+}
+
+// ResetCalendarFeedKey replaces the secret key of the user's calendar feeds.
+func (m *MemoryStore) ResetCalendarFeedKey(userID, newKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.setFeedKey(userID, newKey)
+}
+
+// setFeedKey stores the calendar feed key of the user. m.mu must be held.
+func (m *MemoryStore) setFeedKey(userID, key string) error {
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("User %q does not exist", userID)
+	}
+	m.feedKeys[userID] = key
+	return nil
+}
+
+// CalendarFeedUser returns the id of the user whose calendar feeds use key,
+// or sql.ErrNoRows if there is none.
+func (m *MemoryStore) CalendarFeedUser(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for userID, k := range m.feedKeys {
+		if k == key {
+			return userID, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+// CreateLocalAccount stores a new account, setting its ID and CreatedAt. It
+// returns ErrEmailTaken if another account has the same email address.
+func (m *MemoryStore) CreateLocalAccount(a *LocalAccount) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, other := range m.accounts {
+		if other.Email == a.Email {
+			return ErrEmailTaken
+		}
+	}
+	a.ID = len(m.accounts) + 1
+	a.CreatedAt = m.now()
+	a.FailedLogins = 0
+	a.LockedUntil = nil
+	m.accounts[a.ID] = *a
+	return nil
+}
+
+// LocalAccountByEmail returns the account with the given email address, or
+// sql.ErrNoRows if there is none.
+func (m *MemoryStore) LocalAccountByEmail(email string) (*LocalAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, a := range m.accounts {
+		if a.Email == email {
+			return &a, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// LocalAccount returns the account with the given id, or sql.ErrNoRows if
+// there is none.
+func (m *MemoryStore) LocalAccount(id int) (*LocalAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.accounts[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &a, nil
+}
+
+// RecordFailedLogin counts a failed login to the account, locking it for
+// lockout after maxFailures failures in a row, like
+// Database.RecordFailedLogin.
+func (m *MemoryStore) RecordFailedLogin(id, maxFailures int, lockout time.Duration) (*LocalAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.accounts[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if a.FailedLogins+1 >= maxFailures {
+		until := m.now().Add(lockout)
+		a.FailedLogins = 0
+		a.LockedUntil = &until
+	} else {
+		a.FailedLogins++
+	}
+	m.accounts[id] = a
+	return &a, nil
+}
+
+// ResetFailedLogins clears the failed login count of the account.
+func (m *MemoryStore) ResetFailedLogins(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a, ok := m.accounts[id]; ok {
+		a.FailedLogins = 0
+		a.LockedUntil = nil
+		m.accounts[id] = a
+	}
+	return nil
+}
+
+// CreatePasswordReset stores a password reset token for the account under
+// the given hash. The token can be used until expires.
+func (m *MemoryStore) CreatePasswordReset(accountID int, hash string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.accounts[accountID]; !ok {
+		return fmt.Errorf("Local account %d does not exist", accountID)
+	}
+	m.resets = append(m.resets, memoryReset{accountID: accountID, hash: hash, expires: expires})
+	return nil
+}
+
+// PasswordResetAccount returns the account of the unused, unexpired reset
+// token stored under hash, or sql.ErrNoRows if there is none.
+func (m *MemoryStore) PasswordResetAccount(hash string) (*LocalAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reset := m.usableReset(hash)
+	if reset == nil {
+		return nil, sql.ErrNoRows
+	}
+	a := m.accounts[reset.accountID]
+	return &a, nil
+}
+
+// ResetPassword sets the password hash of the account of the reset token
+// stored under hash, uses up all of the account's reset tokens and unlocks
+// the account. It returns sql.ErrNoRows if the token cannot be used.
+func (m *MemoryStore) ResetPassword(hash, passwordHash string) (*LocalAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reset := m.usableReset(hash)
+	if reset == nil {
+		return nil, sql.ErrNoRows
+	}
+	for i := range m.resets {
+		if m.resets[i].accountID == reset.accountID {
+			m.resets[i].used = true
+		}
+	}
+	a := m.accounts[reset.accountID]
+	a.PasswordHash = passwordHash
+	a.FailedLogins = 0
+	a.LockedUntil = nil
+	m.accounts[a.ID] = a
+	return &a, nil
+}
+
+// usableReset returns the unused, unexpired reset token stored under hash,
+// or nil if there is none. m.mu must be held.
+func (m *MemoryStore) usableReset(hash string) *memoryReset {
+	for i := range m.resets {
+		r := &m.resets[i]
+		if r.hash == hash && !r.used && r.expires.After(m.now()) {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestMemoryStore returns a MemoryStore whose clock is stopped at now,
+// with a user 'user'.
+func newTestMemoryStore(t *testing.T, now time.Time) *MemoryStore {
+	m := NewMemoryStore()
+	m.now = func() time.Time { return now }
+	if err := m.SaveLogin(&User{ID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMemoryStoreListEvents(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	m := newTestMemoryStore(t, now)
+	for _, days := range []int{-1, 3, 1, 2, 2} {
+		e := &Event{CreatorID: "user", Title: "Event", Start: now.AddDate(0, 0, days), Topic: 1, Type: 1}
+		if err := m.CreateEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.HideEvent(2, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int
+	filter := EventFilter{Limit: 2}
+	for {
+		events, next, err := m.ListEvents(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		if next == nil {
+			break
+		}
+		filter.After = next
+	}
+	// Event 1 has started and event 2 is hidden; the rest are ordered by
+	// start time and id.
+	want := []int{3, 4, 5}
+	if len(ids) != len(want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("ids = %v, want %v", ids, want)
+		}
+	}
+}
+
+func TestMemoryStoreSetPreferencesRepeated(t *testing.T) {
+	m := newTestMemoryStore(t, time.Now())
+	// Forms can submit the same id more than once, as in 'topic=1&topic=1'.
+	if err := m.SetPreferences("user", []int{2, 1, 2}, []int{1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	topics, err := m.UserTopics("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := m.UserTypes("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 2 || topics[0] != 1 || topics[1] != 2 {
+		t.Errorf("topics = %v, want [1 2]", topics)
+	}
+	if len(types) != 1 || types[0] != 1 {
+		t.Errorf("types = %v, want [1]", types)
+	}
+}
+
+func TestMemoryStoreAttend(t *testing.T) {
+	m := newTestMemoryStore(t, time.Now())
+	e := &Event{CreatorID: "user", Title: "March", Start: time.Now().Add(time.Hour)}
+	if err := m.CreateEvent(e); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.Attend("user", e.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, _ := m.GetEvent(e.ID)
+	if got.UserCount != 1 {
+		t.Errorf("user count after attending twice = %d, want 1", got.UserCount)
+	}
+	if err := m.Attend("nobody", e.ID); err == nil {
+		t.Error("unknown user could attend")
+	}
+
+	if err := m.Unattend("user", e.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = m.GetEvent(e.ID)
+	if attending, _ := m.IsAttending("user", e.ID); attending || got.UserCount != 0 {
+		t.Errorf("after unattending: attending = %v, user count = %d", attending, got.UserCount)
+	}
+}
+
+func TestMemoryStoreSearchEvents(t *testing.T) {
+	m := newTestMemoryStore(t, time.Now())
+	for _, title := range []string{"Climate march", "Bake sale", "Climate strike <b>"} {
+		if err := m.CreateEvent(&Event{CreatorID: "user", Title: title, Description: "climate"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := m.SearchEvents("CLIMATE strike", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if want := "<mark>Climate</mark> <mark>strike</mark> &lt;b&gt;"; string(results[0].TitleHTML) != want {
+		t.Errorf("TitleHTML = %q, want %q", results[0].TitleHTML, want)
+	}
+}
@@ -0,0 +1,318 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migration is a numbered change to the database schema, with the SQL that
+// applies it and the SQL that reverts it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is a migration along with when it was applied, which is
+// nil for pending migrations.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// migrationLockKey is the key of the Postgres advisory lock held while
+// migrating, so that concurrent runs wait for each other instead of
+// applying the same migrations twice.
+const migrationLockKey = 0x70705f6d69677261
+
+// migrationFile matches the names of migration files, such as
+// '0001_initial.up.sql' and '0001_initial.down.sql'.
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// LoadMigrations reads the migrations in dir, ordered by version. Every
+// migration needs both an up and a down file, and versions must be unique.
+func LoadMigrations(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, f := range files {
+		m := migrationFile.FindStringSubmatch(f.Name())
+		if m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		if version == 0 {
+			return nil, fmt.Errorf("Migration %s: versions start at 1", f.Name())
+		}
+		sqlText, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("Migration %d has two names, %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(sqlText)
+		} else {
+			mig.Down = string(sqlText)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("Migration %04d_%s needs both an up and a down file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrationStatus returns the state of each of migrations. Versions that
+// were applied but are missing from migrations are returned with an empty
+// name and SQL, since the database no longer matches the migration files.
+func (db *Database) MigrationStatus(migrations []Migration) ([]MigrationState, error) {
+	var states []MigrationState
+	err := db.withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		states = migrationStates(migrations, applied)
+		return nil
+	})
+	return states, err
+}
+
+// MigrateUp applies the pending migrations up to and including version to,
+// or all of them if to is zero, and returns the applied migrations. Each
+// migration runs in its own transaction, so a failing migration leaves the
+// ones before it applied. The migrations can read settings, such as the
+// app's time zone, with current_setting.
+func (db *Database) MigrateUp(migrations []Migration, to int, settings map[string]string) ([]Migration, error) {
+	var done []Migration
+	err := db.withMigrationLock(func(conn *sql.Conn) error {
+		for name, value := range settings {
+			_, err := conn.ExecContext(context.Background(), `SELECT set_config($1, $2, false)`, name, value)
+			if err != nil {
+				return err
+			}
+		}
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if to > 0 && m.Version > to {
+				break
+			}
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err := inConnTx(conn, func(tx *sql.Tx) error {
+				if _, err := tx.Exec(m.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("Migration %04d_%s failed: %v", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and
+// returns the reverted migrations.
+func (db *Database) MigrateDown(migrations []Migration, steps int) ([]Migration, error) {
+	var done []Migration
+	err := db.withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		states := migrationStates(migrations, applied)
+		for i := len(states) - 1; i >= 0 && len(done) < steps; i-- {
+			m := states[i]
+			if m.AppliedAt == nil {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("Migration %04d was applied but has no migration files", m.Version)
+			}
+			err := inConnTx(conn, func(tx *sql.Tx) error {
+				if _, err := tx.Exec(m.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("Reverting migration %04d_%s failed: %v", m.Version, m.Name, err)
+			}
+			done = append(done, m.Migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// originalTables are the tables of the original schema, which sql/schema.sql
+// created before migrations were introduced, and which 0001_initial creates.
+var originalTables = []string{"event_type", "event_topic", "event", "user_event_topics", "user_event_types", "user_events"}
+
+// Baseline records the first of migrations, which creates the original
+// schema, as applied without running it, and returns it if it was not
+// already recorded. It adopts databases created from sql/schema.sql, so it
+// refuses to run unless the database has the original tables and none of
+// the later ones, which the following migrations add.
+func (db *Database) Baseline(migrations []Migration) ([]Migration, error) {
+	if len(migrations) == 0 || migrations[0].Version != 1 {
+		return nil, fmt.Errorf("There is no migration 0001 to record")
+	}
+	var done []Migration
+	err := db.withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		if _, ok := applied[1]; ok {
+			return nil
+		}
+
+		for _, table := range originalTables {
+			exists, err := tableExists(conn, table)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("The database has no %s table, apply the migrations instead", table)
+			}
+		}
+		if exists, err := tableExists(conn, "users"); err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("The database has a users table, which the original schema did not have, so it cannot be adopted")
+		}
+
+		m := migrations[0]
+		_, err = conn.ExecContext(context.Background(), `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+		if err == nil {
+			done = append(done, m)
+		}
+		return err
+	})
+	return done, err
+}
+
+// tableExists reports whether the search path of conn has the given table.
+func tableExists(conn *sql.Conn, table string) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(context.Background(), `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists)
+	return exists, err
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// lock, after creating the schema_migrations table if needed.
+func (db *Database) withMigrationLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Advisory locks belong to the session, so they must be taken and
+	// released on the connection the migrations run on.
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, int64(migrationLockKey)); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, int64(migrationLockKey))
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+				version     integer PRIMARY KEY,
+				name        varchar NOT NULL,
+				applied_at  timestamptz NOT NULL DEFAULT now()
+			)`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// appliedMigrations returns when each applied migration version was applied.
+func appliedMigrations(conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(context.Background(), `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// migrationStates merges migrations with the applied versions, ordered by
+// version.
+func migrationStates(migrations []Migration, applied map[int]time.Time) []MigrationState {
+	states := []MigrationState{}
+	known := map[int]bool{}
+	for _, m := range migrations {
+		known[m.Version] = true
+		s := MigrationState{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			s.AppliedAt = &at
+		}
+		states = append(states, s)
+	}
+	for version, at := range applied {
+		if !known[version] {
+			at := at
+			states = append(states, MigrationState{Migration: Migration{Version: version}, AppliedAt: &at})
+		}
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states
+}
+
+// inConnTx is like inTx, but runs the transaction on conn.
+func inConnTx(conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadMigrationsOfApp(t *testing.T) {
+	migrations, err := LoadMigrations("../sql/migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 || migrations[0].Name != "initial" {
+		t.Fatalf("migrations = %+v, want 0001_initial first", migrations)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d, want versions without gaps", i, m.Version)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []int
+		ok    bool
+	}{
+		{"ordered by version", []string{"0002_b.up.sql", "0002_b.down.sql", "0010_c.up.sql", "0010_c.down.sql", "0001_a.up.sql", "0001_a.down.sql", "README"}, []int{1, 2, 10}, true},
+		{"missing down", []string{"0001_a.up.sql"}, nil, false},
+		{"two names", []string{"0001_a.up.sql", "0001_b.down.sql"}, nil, false},
+		{"version zero", []string{"0000_a.up.sql", "0000_a.down.sql"}, nil, false},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "migrations")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range tt.files {
+			if err := ioutil.WriteFile(filepath.Join(dir, f), []byte("SELECT 1;"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		migrations, err := LoadMigrations(dir)
+		os.RemoveAll(dir)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tt.name, err, tt.ok)
+			continue
+		}
+		var got []int
+		for _, m := range migrations {
+			got = append(got, m.Version)
+		}
+		if tt.ok && !equalInts(got, tt.want) {
+			t.Errorf("%s: versions = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMigrationStates(t *testing.T) {
+	migrations := []Migration{{Version: 1, Name: "a"}, {Version: 2, Name: "b"}}
+	applied := map[int]time.Time{1: time.Now(), 3: time.Now()}
+
+	states := migrationStates(migrations, applied)
+	var got []string
+	for _, s := range states {
+		status := "-"
+		if s.AppliedAt != nil {
+			status = "+"
+		}
+		got = append(got, s.Name+status)
+	}
+	if want := "a+ b- +"; strings.Join(got, " ") != want {
+		t.Errorf("states = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package db
+
+import "database/sql"
+
+// UserTopics returns the ids of the event topics the user follows.
+func (db *Database) UserTopics(userID string) ([]int, error) {
+	return db.ids(`SELECT topic_id FROM user_event_topics WHERE user_id = $1 ORDER BY topic_id`, userID)
+}
+
+// UserTypes returns the ids of the event types the user follows.
+func (db *Database) UserTypes(userID string) ([]int, error) {
+	return db.ids(`SELECT type_id FROM user_event_types WHERE user_id = $1 ORDER BY type_id`, userID)
+}
+
+// SetPreferences replaces the event topics and types the user follows.
+// Repeated ids are followed once.
+func (db *Database) SetPreferences(userID string, topicIDs, typeIDs []int) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM user_event_topics WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM user_event_types WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		for _, id := range topicIDs {
+			if _, err := tx.Exec(`INSERT INTO user_event_topics (user_id, topic_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, userID, id); err != nil {
+				return err
+			}
+		}
+		for _, id := range typeIDs {
+			if _, err := tx.Exec(`INSERT INTO user_event_types (user_id, type_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, userID, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RecommendedEvents returns up to limit upcoming events whose topic or type
+// the user follows. Events matching the topic rank above those matching only
+// the type, and sooner events rank above later ones.
+func (db *Database) RecommendedEvents(userID string, limit int) ([]EventSummary, error) {
+	query := `SELECT
+				e.id, e.title, e.start_timestamp, e.time_zone
+			FROM event e
+			LEFT JOIN user_event_topics ut ON ut.user_id = $1 AND ut.topic_id = e.event_topic
+			LEFT JOIN user_event_types uy ON uy.user_id = $1 AND uy.type_id = e.event_type
+			WHERE e.start_timestamp >= now()
+			  AND e.hidden_at IS NULL
+			  AND (ut.topic_id IS NOT NULL OR uy.type_id IS NOT NULL)
+			ORDER BY
+				(CASE WHEN ut.topic_id IS NOT NULL THEN 2 ELSE 0 END +
+				 CASE WHEN uy.type_id IS NOT NULL THEN 1 ELSE 0 END)
+				/ (1 + EXTRACT(EPOCH FROM e.start_timestamp - now()) / 604800) DESC,
+				e.start_timestamp, e.id
+			LIMIT $2`
+	rows, err := db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []EventSummary{}
+	for rows.Next() {
+		var e EventSummary
+		if err := rows.Scan(&e.ID, &e.Title, &e.Start, &e.TimeZone); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ids runs a query selecting a single integer column and collects the rows.
+func (db *Database) ids(query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
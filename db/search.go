@@ -0,0 +1,72 @@
+package db
+
+import (
+	"html"
+	"html/template"
+	"strings"
+)
+
+// Markers wrapped around matched words by ts_headline. They are chosen to
+// survive HTML escaping so they can be swapped for <mark> tags afterwards.
+const (
+	highlightStart = "[[mark]]"
+	highlightStop  = "[[/mark]]"
+)
+
+// SearchResult is an event matching a full-text search, along with
+// highlighted snippets of the matching text.
+type SearchResult struct {
+	EventSummary
+	Rank float64 `json:"rank"`
+	// TitleHTML and SnippetHTML are HTML escaped with matches wrapped in
+	// <mark> tags.
+	TitleHTML   template.HTML `json:"title_html"`
+	SnippetHTML template.HTML `json:"snippet_html"`
+}
+
+// SearchEvents returns up to limit events whose title, description or
+// location match the search terms in q, best matches first.
+func (db *Database) SearchEvents(q string, limit int) ([]SearchResult, error) {
+	opts := "StartSel=" + highlightStart + ", StopSel=" + highlightStop
+	query := `SELECT
+				e.id, e.title, e.start_timestamp, e.time_zone,
+				ts_rank(e.search_vector, q) AS rank,
+				ts_headline('english', COALESCE(e.title, ''), q, $2),
+				ts_headline('english', COALESCE(e.description, '') || ' ' || COALESCE(e.location, ''), q,
+					$2 || ', MaxFragments=2, MaxWords=25, MinWords=10')
+			FROM event e, plainto_tsquery('english', $1) q
+			WHERE e.search_vector @@ q AND e.hidden_at IS NULL
+			ORDER BY rank DESC, e.start_timestamp, e.id
+			LIMIT $3`
+	rows, err := db.Query(query, q, opts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		var title, snippet string
+		err := rows.Scan(
+			&res.ID, &res.Title, &res.Start, &res.TimeZone,
+			&res.Rank, &title, &snippet,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res.TitleHTML = highlight(title)
+		res.SnippetHTML = highlight(snippet)
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// highlight escapes a ts_headline result and replaces its markers with
+// <mark> tags.
+func highlight(s string) template.HTML {
+	s = html.EscapeString(s)
+	s = strings.Replace(s, highlightStart, "<mark>", -1)
+	s = strings.Replace(s, highlightStop, "</mark>", -1)
+	return template.HTML(s)
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Session is a login session stored on the server. Data holds the encoded
+// session values.
+type Session struct {
+	ID        string
+	UserID    string
+	Data      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// SaveSession creates or replaces the session with s.ID.
+func (db *Database) SaveSession(s *Session) error {
+	var userID sql.NullString
+	if s.UserID != "" {
+		userID = sql.NullString{String: s.UserID, Valid: true}
+	}
+	query := `INSERT INTO http_session (id, user_id, data, expires_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (id) DO UPDATE SET
+				user_id = EXCLUDED.user_id,
+				data = EXCLUDED.data,
+				expires_at = EXCLUDED.expires_at,
+				updated_at = now()
+			RETURNING created_at, updated_at`
+	return db.QueryRow(query, s.ID, userID, s.Data, s.ExpiresAt).Scan(&s.CreatedAt, &s.UpdatedAt)
+}
+
+// GetSession returns the unexpired session with the given id, or
+// sql.ErrNoRows if there is none.
+func (db *Database) GetSession(id string) (*Session, error) {
+	query := `SELECT
+				id, user_id, data, created_at, updated_at, expires_at
+			FROM http_session
+			WHERE id = $1 AND expires_at > now()`
+	return scanSession(db.QueryRow(query, id))
+}
+
+// DeleteSession deletes the session with the given id.
+func (db *Database) DeleteSession(id string) error {
+	_, err := db.Exec(`DELETE FROM http_session WHERE id = $1`, id)
+	return err
+}
+
+// UserSessions returns the unexpired sessions of the user, most recently
+// used first.
+func (db *Database) UserSessions(userID string) ([]Session, error) {
+	query := `SELECT
+				id, user_id, data, created_at, updated_at, expires_at
+			FROM http_session
+			WHERE user_id = $1 AND expires_at > now()
+			ORDER BY updated_at DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteUserSessions deletes all sessions of the user, logging them out on
+// every device. It returns the number of sessions deleted.
+func (db *Database) DeleteUserSessions(userID string) (int, error) {
+	res, err := db.Exec(`DELETE FROM http_session WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// DeleteExpiredSessions deletes the sessions that have expired and returns
+// how many there were.
+func (db *Database) DeleteExpiredSessions() (int, error) {
+	res, err := db.Exec(`DELETE FROM http_session WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// scanSession scans the columns selected by the http_session queries.
+func scanSession(row scanner) (*Session, error) {
+	s := &Session{}
+	var userID sql.NullString
+	err := row.Scan(&s.ID, &userID, &s.Data, &s.CreatedAt, &s.UpdatedAt, &s.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	s.UserID = userID.String
+	return s, nil
+}
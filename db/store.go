@@ -0,0 +1,88 @@
+package db
+
+import "time"
+
+// Store is everything the app's handlers keep about users, events and
+// their relations. It is implemented by Database, which keeps them in
+// Postgres, and by MemoryStore, which keeps them in memory for tests.
+type Store interface {
+	UserStore
+	EventStore
+	RSVPStore
+	PreferenceStore
+	LookupStore
+	TokenStore
+	CalendarStore
+	AccountStore
+}
+
+// EventStore stores events. Missing events are reported as sql.ErrNoRows.
+type EventStore interface {
+	GetEvent(id int) (*Event, error)
+	ListEvents(f EventFilter) ([]EventSummary, *Cursor, error)
+	SearchEvents(q string, limit int) ([]SearchResult, error)
+	RecentEvents(topic, typ, limit int) ([]Event, error)
+	EventsCreatedBy(userID string) ([]Event, error)
+	EventsInUserTopics(userID string, since time.Time) ([]Event, error)
+	CreateEvent(e *Event) error
+	CreateEvents(events []*Event) error
+	UpdateEvent(e *Event) error
+	DeleteEvent(id int) error
+	HideEvent(id int, by string) error
+	UnhideEvent(id int) error
+}
+
+// RSVPStore stores which events users have marked as attending.
+type RSVPStore interface {
+	IsAttending(userID string, eventID int) (bool, error)
+	Attend(userID string, eventID int) error
+	Unattend(userID string, eventID int) error
+	GetMyEvents(userID string) ([]EventSummary, error)
+	EventsAttending(userID string) ([]Event, error)
+}
+
+// PreferenceStore stores the event topics and types users follow.
+type PreferenceStore interface {
+	UserTopics(userID string) ([]int, error)
+	UserTypes(userID string) ([]int, error)
+	SetPreferences(userID string, topicIDs, typeIDs []int) error
+	RecommendedEvents(userID string, limit int) ([]EventSummary, error)
+}
+
+// LookupStore stores the event topics and types events are classified by.
+type LookupStore interface {
+	EventTopics() ([]Lookup, error)
+	EventTypes() ([]Lookup, error)
+}
+
+// TokenStore stores the personal API tokens of users.
+type TokenStore interface {
+	CreateAPIToken(userID, name string, scopes []string, hash string) (*APIToken, error)
+	APITokens(userID string) ([]APIToken, error)
+	UseAPIToken(hash string) (*APIToken, error)
+	RevokeAPIToken(userID string, id int) error
+}
+
+// CalendarStore stores the secret keys of users' calendar feeds.
+type CalendarStore interface {
+	CalendarFeedKey(userID, newKey string) (string, error)
+	ResetCalendarFeedKey(userID, newKey string) error
+	CalendarFeedUser(key string) (string, error)
+}
+
+// AccountStore stores local accounts and their password resets.
+type AccountStore interface {
+	CreateLocalAccount(a *LocalAccount) error
+	LocalAccountByEmail(email string) (*LocalAccount, error)
+	LocalAccount(id int) (*LocalAccount, error)
+	RecordFailedLogin(id, maxFailures int, lockout time.Duration) (*LocalAccount, error)
+	ResetFailedLogins(id int) error
+	CreatePasswordReset(accountID int, hash string, expires time.Time) error
+	PasswordResetAccount(hash string) (*LocalAccount, error)
+	ResetPassword(hash, passwordHash string) (*LocalAccount, error)
+}
+
+var (
+	_ Store = (*Database)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Scopes that may be granted to an API token.
+const (
+	// ScopeRead allows safe requests such as GET.
+	ScopeRead = "read"
+	// ScopeWrite allows requests that change data.
+	ScopeWrite = "write"
+)
+
+// APIToken is a personal API token a user has created for non-browser
+// clients. Only a hash of the token itself is stored.
+type APIToken struct {
+	ID         int
+	UserID     string
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIToken stores a new token for the user under the given hash.
+func (db *Database) CreateAPIToken(userID, name string, scopes []string, hash string) (*APIToken, error) {
+	t := &APIToken{UserID: userID, Name: name, Scopes: scopes}
+	query := `INSERT INTO api_token (user_id, name, scopes, token_hash)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at`
+	err := db.QueryRow(query, userID, name, strings.Join(scopes, ","), hash).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// APITokens returns the user's tokens that have not been revoked, newest first.
+func (db *Database) APITokens(userID string) ([]APIToken, error) {
+	query := `SELECT
+				id, user_id, name, scopes, created_at, last_used_at
+			FROM api_token
+			WHERE user_id = $1 AND revoked_at IS NULL
+			ORDER BY created_at DESC, id DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// UseAPIToken returns the unrevoked token stored under hash and records that
+// it was used, or returns sql.ErrNoRows if there is none.
+func (db *Database) UseAPIToken(hash string) (*APIToken, error) {
+	query := `UPDATE api_token
+			SET last_used_at = now()
+			WHERE token_hash = $1 AND revoked_at IS NULL
+			RETURNING id, user_id, name, scopes, created_at, last_used_at`
+	return scanAPIToken(db.QueryRow(query, hash))
+}
+
+// RevokeAPIToken revokes one of the user's tokens. Revoking a token that
+// does not belong to the user has no effect.
+func (db *Database) RevokeAPIToken(userID string, id int) error {
+	_, err := db.Exec(`UPDATE api_token
+			SET revoked_at = now()
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID)
+	return err
+}
+
+// scanAPIToken scans the columns selected by the api_token queries.
+func scanAPIToken(row scanner) (*APIToken, error) {
+	t := &APIToken{}
+	var scopes string
+	var lastUsed pq.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.CreatedAt, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	return t, nil
+}
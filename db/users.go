@@ -0,0 +1,166 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// User is a person who has logged in to the app, through an identity
+// provider or with a local account.
+type User struct {
+	// ID is the id given by the user's identity provider, or 'local|' and
+	// the local account id.
+	ID          string
+	DisplayName string
+	Email       string
+	Picture     string
+	Role        Role
+	// TimeZone is the IANA name of the time zone the user sees times in,
+	// or empty to use the app's default zone.
+	TimeZone    string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+// Role decides what a user may do in the app. Each role may do everything
+// the roles before it in Roles may do.
+type Role string
+
+// The roles of users. New users are members.
+const (
+	// RoleMember users can create, import and mark events and manage their
+	// own settings.
+	RoleMember Role = "member"
+	// RoleOrganizer marks users who organize events for others. It grants
+	// nothing more than RoleMember, since every user may create events.
+	RoleOrganizer Role = "organizer"
+	// RoleModerator users can also edit and hide the events of others.
+	RoleModerator Role = "moderator"
+	// RoleAdmin users can also assign roles and end others' sessions.
+	RoleAdmin Role = "admin"
+)
+
+// Roles lists the roles from least to most privileged.
+var Roles = []Role{RoleMember, RoleOrganizer, RoleModerator, RoleAdmin}
+
+// ParseRole returns the role with the given name.
+func ParseRole(name string) (Role, error) {
+	for _, r := range Roles {
+		if string(r) == name {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown role %q", name)
+}
+
+// rank returns the position of the role in Roles, or -1 for unknown roles
+// such as the empty role of anonymous visitors.
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if role == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// AtLeast reports whether the role may do everything min may do.
+func (r Role) AtLeast(min Role) bool {
+	return r.rank() >= 0 && r.rank() >= min.rank()
+}
+
+// CanModerate reports whether the role may edit and hide any event.
+func (r Role) CanModerate() bool { return r.AtLeast(RoleModerator) }
+
+// CanAdminister reports whether the role may assign roles and end the
+// sessions of other users.
+func (r Role) CanAdminister() bool { return r.AtLeast(RoleAdmin) }
+
+// UserStore stores the users of the app.
+type UserStore interface {
+	SaveLogin(u *User) error
+	GetUser(id string) (*User, error)
+	ListUsers(search string, limit int) ([]User, error)
+	SetUserRole(id string, role Role) error
+	SetUserTimeZone(id, zone string) error
+}
+
+// userColumns are the columns of the users table scanned by scanUser.
+const userColumns = `id, display_name, email, picture, role, time_zone, created_at, last_login_at`
+
+// scanUser scans a row selected with userColumns.
+func scanUser(row scanner) (*User, error) {
+	u := &User{}
+	err := row.Scan(&u.ID, &u.DisplayName, &u.Email, &u.Picture, &u.Role, &u.TimeZone, &u.CreatedAt, &u.LastLoginAt)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// SaveLogin records a login of the user, creating them on their first
+// login and otherwise updating their details. It sets Role, TimeZone,
+// CreatedAt and LastLoginAt.
+func (db *Database) SaveLogin(u *User) error {
+	query := `INSERT INTO users (id, display_name, email, picture)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (id) DO UPDATE SET
+				display_name = EXCLUDED.display_name,
+				email = EXCLUDED.email,
+				picture = EXCLUDED.picture,
+				last_login_at = now()
+			RETURNING role, time_zone, created_at, last_login_at`
+	return db.QueryRow(query, u.ID, u.DisplayName, u.Email, u.Picture).Scan(&u.Role, &u.TimeZone, &u.CreatedAt, &u.LastLoginAt)
+}
+
+// GetUser returns the user with the given id, or sql.ErrNoRows if there is
+// none.
+func (db *Database) GetUser(id string) (*User, error) {
+	query := `SELECT ` + userColumns + `
+			FROM users
+			WHERE id = $1`
+	return scanUser(db.QueryRow(query, id))
+}
+
+// ListUsers returns up to limit users whose display name, email address or
+// id contain search, ordered by display name. An empty search matches all
+// users.
+func (db *Database) ListUsers(search string, limit int) ([]User, error) {
+	query := `SELECT ` + userColumns + `
+			FROM users
+			WHERE $1 = ''
+			   OR display_name ILIKE '%' || $1 || '%'
+			   OR email ILIKE '%' || $1 || '%'
+			   OR id ILIKE '%' || $1 || '%'
+			ORDER BY display_name, id
+			LIMIT $2`
+	rows, err := db.Query(query, search, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// SetUserRole changes the role of the user with the given id. It returns
+// sql.ErrNoRows if there is no such user.
+func (db *Database) SetUserRole(id string, role Role) error {
+	var found string
+	return db.QueryRow(`UPDATE users SET role = $2 WHERE id = $1 RETURNING id`, id, string(role)).Scan(&found)
+}
+
+// SetUserTimeZone changes the time zone the user with the given id sees
+// times in. It returns sql.ErrNoRows if there is no such user.
+func (db *Database) SetUserTimeZone(id, zone string) error {
+	var found string
+	return db.QueryRow(`UPDATE users SET time_zone = $2 WHERE id = $1 RETURNING id`, id, zone).Scan(&found)
+}
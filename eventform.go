@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chloearianne/protestpulse/db"
+)
+
+// eventInput holds the event fields submitted by a client, either from the
+// event forms or as a JSON body. Fields that were not submitted are nil.
+type eventInput struct {
+	Title       *string    `json:"title"`
+	Start       *time.Time `json:"start"`
+	End         *time.Time `json:"end"`
+	Description *string    `json:"description"`
+	Type        *int       `json:"type"`
+	Topic       *int       `json:"topic"`
+	Location    *string    `json:"location"`
+	TimeZone    *string    `json:"time_zone"`
+
+	// wallClock is set when Start and End were read from the event forms,
+	// as times on the clock in the event's time zone rather than instants.
+	wallClock bool
+	// errs holds the problems found while reading the form fields, such as
+	// a date that does not parse, keyed like fieldErrors.
+	errs fieldErrors
+}
+
+// errUnsupportedMediaType is returned by decodeEventInput for request bodies
+// that are neither JSON nor form encoded.
+var errUnsupportedMediaType = fmt.Errorf("Request body must be JSON or form encoded")
+
+// decodeEventInput reads the event fields from the request body, which may
+// be JSON or form encoded.
+func decodeEventInput(r *http.Request) (*eventInput, error) {
+	in := &eventInput{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+			return nil, fmt.Errorf("Invalid JSON body: %v", err)
+		}
+		return in, nil
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		return in, in.parseForm(r)
+	default:
+		return nil, errUnsupportedMediaType
+	}
+}
+
+// parseForm reads the fields of the event forms, where start and end are
+// each split into separate date and time inputs. Values that cannot be
+// read are recorded in in.errs rather than failing the request, so that
+// the form can be shown again with the problems.
+func (in *eventInput) parseForm(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	in.errs = fieldErrors{}
+	in.wallClock = true
+	in.Title = formString(r, "title")
+	in.Description = formString(r, "description")
+	in.Location = formString(r, "location")
+	in.TimeZone = formString(r, "time_zone")
+	in.Type = formInt(r, "event_type", "type", in.errs)
+	in.Topic = formInt(r, "event_topic", "topic", in.errs)
+	in.Start = formTime(r, "start_date", "start_time", "start", in.errs)
+	in.End = formTime(r, "end_date", "end_time", "end", in.errs)
+	return nil
+}
+
+// apply copies the submitted fields onto e, leaving the others unchanged.
+// Times from the event forms are placed in the event's time zone.
+func (in *eventInput) apply(e *db.Event) {
+	if in.Title != nil {
+		e.Title = *in.Title
+	}
+	if in.TimeZone != nil {
+		e.TimeZone = *in.TimeZone
+	}
+	if in.Start != nil {
+		e.Start = in.inZone(*in.Start, e.TimeZone)
+	}
+	if in.End != nil {
+		e.End = in.inZone(*in.End, e.TimeZone)
+	}
+	if in.Description != nil {
+		e.Description = *in.Description
+	}
+	if in.Type != nil {
+		e.Type = *in.Type
+	}
+	if in.Topic != nil {
+		e.Topic = *in.Topic
+	}
+	if in.Location != nil {
+		e.Location = *in.Location
+	}
+}
+
+// inZone returns the submitted time t of an event taking place in the zone
+// with the given name. Wall clock times from the event forms are read in
+// that zone, or in UTC if it is unknown, which validation then reports.
+// Other times are instants, and are returned unchanged.
+func (in *eventInput) inZone(t time.Time, zone string) time.Time {
+	if !in.wallClock {
+		return t
+	}
+	loc := zoneOr(zone, time.UTC)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+}
+
+// formString returns the submitted value for key, or nil if the key was not
+// part of the request.
+func formString(r *http.Request, key string) *string {
+	if _, ok := r.Form[key]; !ok {
+		return nil
+	}
+	v := r.Form.Get(key)
+	return &v
+}
+
+// formInt parses the submitted value for key as an integer, or returns nil
+// if the key was not part of the request or left empty, as by the
+// placeholder option of a select. Other values that are not integers are
+// recorded in errs under field.
+func formInt(r *http.Request, key, field string, errs fieldErrors) *int {
+	v := formString(r, key)
+	if v == nil || *v == "" {
+		return nil
+	}
+	i, err := strconv.Atoi(*v)
+	if err != nil {
+		errs.add(field, fmt.Sprintf("Choose a %s from the list", field))
+		return nil
+	}
+	return &i
+}
+
+// formTime combines the submitted date and time fields into a timestamp,
+// or returns nil if neither field was part of the request. Missing halves
+// and values that do not parse are recorded in errs under field.
+func formTime(r *http.Request, dateKey, timeKey, field string, errs fieldErrors) *time.Time {
+	date, clock := formString(r, dateKey), formString(r, timeKey)
+	if date == nil && clock == nil {
+		return nil
+	}
+	if date == nil || clock == nil || *date == "" || *clock == "" {
+		errs.add(field, fmt.Sprintf("Both a %s date and time are required", field))
+		return nil
+	}
+	ts, err := time.Parse(dateTimeFormat, fmt.Sprintf("%s %s", *date, *clock))
+	if err != nil {
+		errs.add(field, fmt.Sprintf("Invalid %s date or time, expected YYYY-MM-DD and HH:MM", field))
+		return nil
+	}
+	return &ts
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/feed"
+	"github.com/gorilla/mux"
+)
+
+// feedEntriesCount is the number of events included in each feed.
+const feedEntriesCount = 50
+
+// FeedGET handles GET requests for '/feeds/events.{format}',
+// '/feeds/topics/{id}.{format}' and '/feeds/types/{id}.{format}', where
+// format is 'atom' or 'rss'. Feeds list the newest events and are public.
+func (a *App) FeedGET(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	topics, types, err := a.lookups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	title := "Protest Pulse: new events"
+	link := a.canonicalURL("/events")
+	var topic, typ int
+	if kind, ok := vars["kind"]; ok {
+		id, _ := strconv.Atoi(vars["id"])
+		var name, param string
+		switch kind {
+		case "topics":
+			name, ok = lookupName(topics, id)
+			topic, param = id, "topic"
+		case "types":
+			name, ok = lookupName(types, id)
+			typ, param = id, "type"
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		title = fmt.Sprintf("Protest Pulse: new %s events", name)
+		link = fmt.Sprintf("%s?%s=%d", link, param, id)
+	}
+
+	events, err := a.store.RecentEvents(topic, typ, feedEntriesCount)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get recent events")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	f := &feed.Feed{
+		ID:    a.canonicalURL(r.URL.Path),
+		Title: title,
+		Link:  link,
+		Self:  a.canonicalURL(r.URL.Path),
+	}
+	// The feed was last modified by its latest event update. Feeds without
+	// events are dated now, and have no Last-Modified time.
+	var modified time.Time
+	for _, e := range events {
+		if e.UpdatedAt.After(modified) {
+			modified = e.UpdatedAt
+		}
+		eventPath := fmt.Sprintf("/events/%d", e.ID)
+		f.Entries = append(f.Entries, feed.Entry{
+			ID:        a.canonicalURL(eventPath),
+			Title:     e.Title,
+			Link:      a.canonicalURL(eventPath),
+			Summary:   a.feedSummary(&e),
+			Published: e.CreatedAt,
+			Updated:   e.UpdatedAt,
+		})
+	}
+
+	f.Updated = modified
+	if f.Updated.IsZero() {
+		f.Updated = time.Now()
+	}
+
+	var body bytes.Buffer
+	if vars["format"] == "atom" {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		err = f.WriteAtom(&body)
+	} else {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		err = f.WriteRSS(&body)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode feed")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// ServeContent answers conditional requests using the ETag and the
+	// Last-Modified time, which is the latest event update.
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha1.Sum(body.Bytes())))
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body.Bytes()))
+}
+
+// feedSummary describes when and where an event takes place, in the
+// event's local time.
+func (a *App) feedSummary(e *db.Event) string {
+	start := e.Start.In(a.eventZone(e.TimeZone)).Format(humanTimeFormat)
+	summary := fmt.Sprintf("%s at %s.", start, e.Location)
+	if e.Description != "" {
+		summary += " " + e.Description
+	}
+	return summary
+}
+
+// lookupName returns the name of the lookup with the given id.
+func lookupName(lookups []db.Lookup, id int) (string, bool) {
+	for _, l := range lookups {
+		if l.ID == id {
+			return l.Name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/chloearianne/protestpulse/db"
+)
+
+// maxLocationFilter is the longest location search accepted by '/events'.
+const maxLocationFilter = 200
+
+// parseEventFilter validates the filter query parameters accepted by
+// '/events' (topic, type, from, to, location and after) and converts them
+// into a db.EventFilter. Topic and type ids must exist in the given lookups.
+// The from and to dates are days in the viewer's time zone.
+func parseEventFilter(q url.Values, topics, types []db.Lookup, viewer *time.Location) (db.EventFilter, error) {
+	f := db.EventFilter{Limit: eventsPageSize}
+
+	var err error
+	if f.Topic, err = parseLookupID(q.Get("topic"), "topic", topics); err != nil {
+		return f, err
+	}
+	if f.Type, err = parseLookupID(q.Get("type"), "type", types); err != nil {
+		return f, err
+	}
+
+	if from := q.Get("from"); from != "" {
+		if f.From, err = time.ParseInLocation(formDateFormat, from, viewer); err != nil {
+			return f, fmt.Errorf("Invalid from date %q, expected YYYY-MM-DD", from)
+		}
+	}
+	if to := q.Get("to"); to != "" {
+		if f.To, err = time.ParseInLocation(formDateFormat, to, viewer); err != nil {
+			return f, fmt.Errorf("Invalid to date %q, expected YYYY-MM-DD", to)
+		}
+		// Include events starting any time on the final day.
+		f.To = f.To.AddDate(0, 0, 1)
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, fmt.Errorf("The from date must not be after the to date")
+	}
+
+	f.Location = q.Get("location")
+	if len(f.Location) > maxLocationFilter {
+		return f, fmt.Errorf("Location must be at most %d characters", maxLocationFilter)
+	}
+
+	if c := q.Get("after"); c != "" {
+		if f.After, err = db.ParseCursor(c); err != nil {
+			return f, err
+		}
+	}
+
+	return f, nil
+}
+
+// parseLookupID parses an optional lookup id parameter, returning 0 if it
+// is empty and an error if it is not one of lookups.
+func parseLookupID(v, name string, lookups []db.Lookup) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err == nil && hasLookup(lookups, id) {
+		return id, nil
+	}
+	return 0, fmt.Errorf("Unknown %s %q", name, v)
+}
+
+// hasLookup reports whether id appears in lookups.
+func hasLookup(lookups []db.Lookup, id int) bool {
+	for _, l := range lookups {
+		if l.ID == id {
+			return true
+		}
+	}
+	return false
+}
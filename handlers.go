@@ -1,156 +1,473 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
+	"html/template"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/chloearianne/protestpulse/session"
+	"github.com/chloearianne/protestpulse/db"
 	"github.com/gorilla/mux"
 )
 
 var humanDateFormat = "Jan 02, 2006"
 
+// eventsPageSize is the number of events shown per page of '/events'.
+const eventsPageSize = 24
+
+// recommendedEventsCount is the number of events shown in the personalized
+// feed on '/'.
+const recommendedEventsCount = 12
+
+// Formats used by the date and time inputs of the event forms.
+var (
+	formDateFormat = "2006-01-02"
+	formTimeFormat = "15:04"
+	dateTimeFormat = formDateFormat + " " + formTimeFormat
+)
+
 // IndexGET handles GET requests for '/'.
 func (a *App) IndexGET(w http.ResponseWriter, r *http.Request) {
-	p, err := session.GetProfile(r, a.cookieStore)
+	p := requestProfile(r)
+
+	forYou, err := a.store.RecommendedEvents(p.UserID, recommendedEventsCount)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
+		logrus.WithError(err).Error("Failed to get recommended events")
 	}
 
 	data := map[string]interface{}{
-		"Page":    "Home",
-		"Profile": p,
+		"Page":       "Home",
+		"Profile":    p,
+		"ProfileURL": userURL(p.UserID),
+		"ForYou":     toEvents(forYou, a.viewerZone(r)),
 	}
 	a.renderTemplate(w, r, "index.tmpl", data)
 }
 
-// EventsPOST handles POST requests for '/events'.
+// EventsPOST handles POST requests for '/events'. Events take place in the
+// creator's time zone unless another is chosen. The user is redirected to
+// the created event, while JSON clients receive it in a 201 response, as
+// from the API. If the submitted event is invalid, the events page is shown
+// again with the create event modal open on the user's input and the
+// problems with it, or JSON clients receive the problems in a 422 response.
 func (a *App) EventsPOST(w http.ResponseWriter, r *http.Request) {
-	p, err := session.GetProfile(r, a.cookieStore)
+	p := requestProfile(r)
+
+	in, err := decodeEventInput(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	r.ParseForm()
-	var dateTimeFormat = "2006-01-02 15:04"
-	startDateTime := fmt.Sprintf("%s %s", r.FormValue("start_date"), r.FormValue("start_time"))
-	startTS, err := time.Parse(dateTimeFormat, startDateTime)
+	e := &db.Event{CreatorID: p.UserID, TimeZone: a.viewerZone(r).String()}
+	in.apply(e)
+	errs, err := a.validateEvent(in, e, time.Time{})
 	if err != nil {
-		logrus.WithError(err).Error("Failed to parse date time")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	endDateTime := fmt.Sprintf("%s %s", r.FormValue("end_date"), r.FormValue("end_time"))
-	endTS, err := time.Parse(dateTimeFormat, endDateTime)
-	if err != nil {
-		logrus.WithError(err).Error("Failed to parse date time")
-	}
-
-	query := `INSERT INTO event (
-		        creator_id, title, start_timestamp,
-		        end_timestamp, description, event_topic,
-		        event_type, location, user_count
-			  )
-			  VALUES (
-			  	$1, $2, $3,
-			  	$4, $5, $6,
-			  	$7, $8, $9
-			  )`
-	_, err = a.db.Exec(query,
-		p.UserID, r.FormValue("title"), startTS,
-		endTS, r.FormValue("description"), r.FormValue("event_topic"),
-		r.FormValue("event_type"), r.FormValue("location"), 0,
-	)
-	if err != nil {
+	if len(errs) > 0 {
+		if wantsJSON(r) {
+			writeFieldErrors(w, errs)
+			return
+		}
+		a.showEvents(w, r, http.StatusUnprocessableEntity, &eventForm{Values: r.PostForm, Errors: errs})
+		return
+	}
+	if err = a.store.CreateEvent(e); err != nil {
 		logrus.WithError(err).Error("Failed to save event")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	a.EventsGET(w, r)
-	return
+	path := fmt.Sprintf("/events/%d", e.ID)
+	if wantsJSON(r) {
+		w.Header().Set("Location", path)
+		writeJSON(w, http.StatusCreated, e)
+		return
+	}
+	http.Redirect(w, r, path, http.StatusSeeOther)
 }
 
 // Event contains the metadata related to an activism event.
 type Event struct {
-	ID        int
-	Title     string
-	Timestamp string
+	ID    int
+	Title string
+	// Timestamp is the start of the event in its own time zone, and
+	// ViewerTimestamp the start in the viewer's zone, if it differs.
+	Timestamp       string
+	ViewerTimestamp string
 }
 
-// EventsGET handles GET requests for '/events'.
+// EventsGET handles GET requests for '/events' by listing upcoming events
+// from all creators, narrowed down by the filters in the query string.
+// Clients that accept JSON receive the list as JSON instead of HTML.
 func (a *App) EventsGET(w http.ResponseWriter, r *http.Request) {
-	p, err := session.GetProfile(r, a.cookieStore)
+	a.showEvents(w, r, http.StatusOK, nil)
+}
+
+// showEvents responds with the events page for the filters in the query
+// string. A non-nil form is shown in the create event modal.
+func (a *App) showEvents(w http.ResponseWriter, r *http.Request, status int, form *eventForm) {
+	topics, types, err := a.lookups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	viewer := a.viewerZone(r)
+	filter, err := parseEventFilter(q, topics, types, viewer)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		if wantsJSON(r) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	query := `SELECT
-				id, title, start_timestamp
-			FROM event
-			WHERE creator_id = $1`
-	rows, err := a.db.Query(query, p.UserID)
+	events, next, err := a.store.ListEvents(filter)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithError(err).Error("Failed to list events")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var nextURL template.URL
+	if next != nil {
+		q.Set("after", next.String())
+		nextURL = template.URL("/events?" + q.Encode())
 	}
-	defer rows.Close()
 
-	eventsMap := []Event{}
-	var id int
-	var title string
-	var startTS time.Time
-	for rows.Next() {
-		if err := rows.Scan(&id, &title, &startTS); err != nil {
-			logrus.Error(err)
+	if wantsJSON(r) {
+		resp := map[string]interface{}{"events": events}
+		if next != nil {
+			resp["next"] = next.String()
 		}
-		eventsMap = append(eventsMap, Event{
-			ID:        id,
-			Title:     title,
-			Timestamp: startTS.Format(humanDateFormat),
-		})
+		writeJSON(w, http.StatusOK, resp)
+		return
 	}
 
 	data := map[string]interface{}{
-		"Page":   "Events",
-		"Events": eventsMap,
+		"Page":     "Events",
+		"Events":   toEvents(events, viewer),
+		"Topics":   topics,
+		"Types":    types,
+		"Filter":   filter,
+		"FromDate": q.Get("from"),
+		"ToDate":   q.Get("to"),
+		"NextURL":  nextURL,
 	}
-	a.renderTemplate(w, r, "events.tmpl", data)
+	if form != nil {
+		data["EventForm"] = form
+	}
+	a.renderTemplateStatus(w, r, status, "events.tmpl", data)
 }
 
 // EventGET handles GET requests for a single event at '/events/{id}'.
+// Anonymous visitors can view the event but not mark it.
 func (a *App) EventGET(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	var title, desc, location string
-	var startTime, endTime time.Time
-	var eventType, topic int
-
-	query := `SELECT
-				title, start_timestamp, end_timestamp,
-				description, event_type, event_topic,
-				location
-			FROM event
-			WHERE id = $1`
-	err := a.db.QueryRow(query, id).Scan(
-		&title, &startTime, &endTime,
-		&desc, &eventType, &topic,
-		&location,
-	)
+	a.showEvent(w, r, http.StatusOK, nil)
+}
+
+// showEvent responds with the page of the event of the request. The values
+// and errors of a non-nil submitted form are shown in the edit form, in
+// place of the event's current fields.
+func (a *App) showEvent(w http.ResponseWriter, r *http.Request, status int, submitted *eventForm) {
+	e, err := a.visibleEvent(r, eventID(r))
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithError(err).Error("Failed to get event")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	zone, viewer := a.eventZone(e.TimeZone), a.viewerZone(r)
+	start, viewerStart := formatInZones(e.Start, zone, viewer)
+	end, viewerEnd := formatInZones(e.End, zone, viewer)
+	values := url.Values{
+		"title":       {e.Title},
+		"event_type":  {strconv.Itoa(e.Type)},
+		"event_topic": {strconv.Itoa(e.Topic)},
+		"description": {e.Description},
+		"location":    {e.Location},
+		"time_zone":   {zone.String()},
+		"start_date":  {e.Start.In(zone).Format(formDateFormat)},
+		"start_time":  {e.Start.In(zone).Format(formTimeFormat)},
+		"end_date":    {e.End.In(zone).Format(formDateFormat)},
+		"end_time":    {e.End.In(zone).Format(formTimeFormat)},
+	}
+	form := &eventForm{Values: values}
+	if submitted != nil {
+		// Fields missing from the submitted form show the event's value.
+		for field, v := range submitted.Values {
+			values[field] = v
+		}
+		form.Errors = submitted.Errors
+	}
 	data := map[string]interface{}{
-		"Page":     "Events",
-		"Title":    title,
-		"Start":    startTime.Format(humanDateFormat),
-		"End":      endTime.Format(humanDateFormat),
-		"Desc":     desc,
-		"Type":     eventType,
-		"Topic":    topic,
-		"Location": location,
-	}
-	a.renderTemplate(w, r, "event.tmpl", data)
+		"Page":        "Events",
+		"ID":          e.ID,
+		"Title":       e.Title,
+		"Start":       start,
+		"End":         end,
+		"ViewerStart": viewerStart,
+		"ViewerEnd":   viewerEnd,
+		"TimeZone":    zone.String(),
+		"Desc":        e.Description,
+		"Type":        e.Type,
+		"Topic":       e.Topic,
+		"Location":    e.Location,
+		"UserCount":   e.UserCount,
+		"Hidden":      e.HiddenAt != nil,
+		"EditForm":    form,
+	}
+
+	if e.CreatorID != "" {
+		creator, err := a.store.GetUser(e.CreatorID)
+		if err != nil && err != sql.ErrNoRows {
+			logrus.WithError(err).Error("Failed to get event creator")
+		}
+		if creator != nil {
+			data["Creator"] = creator
+			data["CreatorURL"] = userURL(creator.ID)
+		}
+	}
+
+	if p := requestProfile(r); p != nil {
+		role := a.viewerRole(r)
+		data["CanEdit"] = e.CreatorID == p.UserID || role.CanModerate()
+		data["CanDelete"] = e.CreatorID == p.UserID || role.CanAdminister()
+		data["CanModerate"] = role.CanModerate()
+
+		attending, err := a.store.IsAttending(p.UserID, e.ID)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to get attendance")
+		}
+		data["Attending"] = attending
+
+		myEvents, err := a.store.GetMyEvents(p.UserID)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to get marked events")
+		}
+		data["MyEvents"] = toEvents(myEvents, viewer)
+	}
+
+	a.renderTemplateStatus(w, r, status, "event.tmpl", data)
+}
+
+// EventPUT handles PUT and PATCH requests for '/events/{id}', as well as
+// POST requests from the edit form on the event page. Only fields present
+// in the request are changed, and only the event's creator and moderators
+// may change it. Changes leaving the event invalid are rejected with a 422
+// response listing the problems, which shows the edit form again for POST
+// requests.
+func (a *App) EventPUT(w http.ResponseWriter, r *http.Request) {
+	e, status, err := a.editedEvent(eventID(r))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	in, err := decodeEventInput(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	oldStart := e.Start
+	in.apply(e)
+	errs, err := a.validateEvent(in, e, oldStart)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		if wantsJSON(r) {
+			writeFieldErrors(w, errs)
+			return
+		}
+		if r.Method == "POST" {
+			a.showEvent(w, r, http.StatusUnprocessableEntity, &eventForm{Values: r.PostForm, Errors: errs})
+			return
+		}
+		http.Error(w, errs.String(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err = a.store.UpdateEvent(e); err != nil {
+		logrus.WithError(err).Error("Failed to update event")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == "POST" {
+		http.Redirect(w, r, fmt.Sprintf("/events/%d", e.ID), http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EventDELETE handles DELETE requests for '/events/{id}', as well as POST
+// requests from the delete form at '/events/{id}/delete'. Only the event's
+// creator and admins may delete it.
+func (a *App) EventDELETE(w http.ResponseWriter, r *http.Request) {
+	if err := a.store.DeleteEvent(eventID(r)); err != nil {
+		logrus.WithError(err).Error("Failed to delete event")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == "POST" {
+		http.Redirect(w, r, "/events", http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EventHidePOST handles POST requests for '/events/{id}/hide' by hiding
+// the event from everyone but its creator and the moderators.
+func (a *App) EventHidePOST(w http.ResponseWriter, r *http.Request) {
+	p := requestProfile(r)
+	a.moderateEvent(w, r, func(id int) error { return a.store.HideEvent(id, p.UserID) })
+}
+
+// EventUnhidePOST handles POST requests for '/events/{id}/unhide' by
+// showing a hidden event again.
+func (a *App) EventUnhidePOST(w http.ResponseWriter, r *http.Request) {
+	a.moderateEvent(w, r, a.store.UnhideEvent)
+}
+
+// moderateEvent applies update to the event in the request path, then
+// redirects back to the event page.
+func (a *App) moderateEvent(w http.ResponseWriter, r *http.Request, update func(id int) error) {
+	id := eventID(r)
+	err := update(id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to moderate event")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/events/%d", id), http.StatusSeeOther)
+}
+
+// EventAttendPOST handles POST requests for '/events/{id}/attend' by
+// marking the event for the current user.
+func (a *App) EventAttendPOST(w http.ResponseWriter, r *http.Request) {
+	a.setAttendance(w, r, a.store.Attend)
+}
+
+// EventUnattendPOST handles POST requests for '/events/{id}/unattend' by
+// unmarking the event for the current user.
+func (a *App) EventUnattendPOST(w http.ResponseWriter, r *http.Request) {
+	a.setAttendance(w, r, a.store.Unattend)
+}
+
+// setAttendance applies update to the current user and the event in the
+// request path, then redirects back to the event page.
+func (a *App) setAttendance(w http.ResponseWriter, r *http.Request, update func(userID string, eventID int) error) {
+	p := requestProfile(r)
+
+	id := eventID(r)
+	if status, err := a.updateAttendance(r, id, p.UserID, update); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/events/%d", id), http.StatusSeeOther)
+}
+
+// updateAttendance applies update to the user and the event with the given
+// id, which must be visible to the logged in user of r like on the event
+// page. On failure it returns the HTTP status describing the error.
+func (a *App) updateAttendance(r *http.Request, id int, userID string, update func(userID string, eventID int) error) (int, error) {
+	_, err := a.visibleEvent(r, id)
+	if err == sql.ErrNoRows {
+		return http.StatusNotFound, fmt.Errorf("Event %d does not exist", id)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get event")
+		return http.StatusInternalServerError, err
+	}
+	if err = update(userID, id); err != nil {
+		logrus.WithError(err).Error("Failed to update attendance")
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// editedEvent returns the event with the given id for a route whose
+// policy already let the user change it. On failure it returns the HTTP
+// status describing the error.
+func (a *App) editedEvent(id int) (*db.Event, int, error) {
+	e, err := a.store.GetEvent(id)
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, fmt.Errorf("Event %d does not exist", id)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get event")
+		return nil, http.StatusInternalServerError, err
+	}
+	return e, http.StatusOK, nil
+}
+
+// visibleEvent returns the event with the given id, or sql.ErrNoRows if
+// there is none or it is hidden from the current user.
+func (a *App) visibleEvent(r *http.Request, id int) (*db.Event, error) {
+	e, err := a.store.GetEvent(id)
+	if err != nil {
+		return nil, err
+	}
+	if e.HiddenAt != nil {
+		p := requestProfile(r)
+		if p == nil || (p.UserID != e.CreatorID && !a.viewerRole(r).CanModerate()) {
+			return nil, sql.ErrNoRows
+		}
+	}
+	return e, nil
+}
+
+// lookups returns the rows of the event_topic and event_type tables.
+func (a *App) lookups() (topics, types []db.Lookup, err error) {
+	if topics, err = a.store.EventTopics(); err != nil {
+		logrus.WithError(err).Error("Failed to get event topics")
+		return nil, nil, err
+	}
+	if types, err = a.store.EventTypes(); err != nil {
+		logrus.WithError(err).Error("Failed to get event types")
+		return nil, nil, err
+	}
+	return topics, types, nil
+}
+
+// eventID returns the event id from the request path. Routes only match
+// numeric ids, so parse errors cannot occur.
+func eventID(r *http.Request) int {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	return id
+}
+
+// toEvents converts event summaries from the database into Events for
+// display to a viewer in the given time zone.
+func toEvents(summaries []db.EventSummary, viewer *time.Location) []Event {
+	events := []Event{}
+	for _, s := range summaries {
+		local, yours := formatInZones(s.Start, zoneOr(s.TimeZone, viewer), viewer)
+		events = append(events, Event{
+			ID:              s.ID,
+			Title:           s.Title,
+			Timestamp:       local,
+			ViewerTimestamp: yours,
+		})
+	}
+	return events
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chloearianne/protestpulse/db"
+)
+
+func TestEventLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	organizer := ts.login("organizer", db.RoleOrganizer)
+	member := ts.login("member", db.RoleMember)
+
+	// An organizer creates an event with the event form.
+	form := url.Values{
+		"title":       {"Climate march"},
+		"description": {"March to city hall"},
+		"location":    {"Central Park"},
+		"event_type":  {"1"},
+		"event_topic": {"2"},
+		"start_date":  {testDate(0)},
+		"start_time":  {"10:00"},
+		"end_date":    {testDate(0)},
+		"end_time":    {"12:00"},
+	}
+	created := ts.submit("POST", "/events", form, organizer)
+	if created.Code != http.StatusSeeOther {
+		t.Fatalf("create: status = %d, body %q", created.Code, created.Body.String())
+	}
+
+	rec := ts.sendJSON("GET", "/api/v1/events?topic=2", nil, nil)
+	var list struct{ Events []db.EventSummary }
+	decodeJSON(t, rec, &list)
+	if len(list.Events) != 1 || list.Events[0].Title != "Climate march" {
+		t.Fatalf("events = %+v, want the created event", list.Events)
+	}
+	path := fmt.Sprintf("/events/%d", list.Events[0].ID)
+	if loc := created.Header().Get("Location"); loc != path {
+		t.Errorf("create: redirected to %q, want %q", loc, path)
+	}
+
+	if rec := ts.get(path, nil); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "March to city hall") {
+		t.Fatalf("event page: status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	// A member marks it, and finds it among their events.
+	if rec := ts.serve(formRequest("POST", path+"/attend", nil), member); rec.Code != http.StatusSeeOther {
+		t.Fatalf("attend: status = %d", rec.Code)
+	}
+	e, err := ts.app.store.GetEvent(list.Events[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.UserCount != 1 {
+		t.Errorf("user count = %d, want 1", e.UserCount)
+	}
+	if rec := ts.sendJSON("GET", "/api/v1/me/events", nil, member); !strings.Contains(rec.Body.String(), "Climate march") {
+		t.Errorf("marked events = %q", rec.Body.String())
+	}
+
+	// The organizer edits it, but the member may not.
+	if rec := ts.submit("POST", path, url.Values{"title": {"Climate strike"}}, member); rec.Code != http.StatusForbidden {
+		t.Errorf("edit by member: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := ts.submit("POST", path, url.Values{"title": {"Climate strike"}}, organizer); rec.Code != http.StatusSeeOther {
+		t.Fatalf("edit: status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	if rec := ts.get(path, nil); !strings.Contains(rec.Body.String(), "Climate strike") {
+		t.Error("event page does not show the new title")
+	}
+
+	// A moderator hides it from everyone but its creator.
+	moderator := ts.login("moderator", db.RoleModerator)
+	if rec := ts.serve(formRequest("POST", path+"/hide", nil), moderator); rec.Code != http.StatusSeeOther {
+		t.Fatalf("hide: status = %d", rec.Code)
+	}
+	if rec := ts.get("/events", nil); strings.Contains(rec.Body.String(), "Climate strike") {
+		t.Error("hidden event is listed")
+	}
+	if rec := ts.get(path, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("hidden event page: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := ts.get(path, organizer); rec.Code != http.StatusOK {
+		t.Errorf("hidden event page of creator: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// The organizer deletes it.
+	if rec := ts.serve(formRequest("POST", path+"/delete", nil), organizer); rec.Code != http.StatusSeeOther {
+		t.Fatalf("delete: status = %d", rec.Code)
+	}
+	if rec := ts.get(path, organizer); rec.Code != http.StatusNotFound {
+		t.Errorf("deleted event page: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestProtectedPagesReturnAfterLogin(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	visitor := ts.anonymous()
+	rec := ts.get("/preferences?tab=topics", visitor)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/auth/login" {
+		t.Fatalf("status = %d, redirected to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if path, _ := sessionValue(t, ts.app, rec, returnToKey).(string); path != "/preferences?tab=topics" {
+		t.Errorf("return path = %q, want %q", path, "/preferences?tab=topics")
+	}
+}
+
+func TestStableEventIdentifiers(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	ts.login("organizer", db.RoleOrganizer)
+	e := ts.createEvent("organizer", "Shared event")
+
+	// Calendar UIDs, feed ids and feed links stay the same whatever host is
+	// requested.
+	for _, host := range []string{"example.com", "proxy.internal:8080"} {
+		for _, tt := range []struct{ path, want string }{
+			{fmt.Sprintf("/events/%d.ics", e.ID), fmt.Sprintf("UID:event-%d@protestpulse.test", e.ID)},
+			{"/feeds/events.atom", fmt.Sprintf("<id>https://protestpulse.test/events/%d</id>", e.ID)},
+			{"/feeds/events.atom", `<link href="https://protestpulse.test/events" rel="alternate">`},
+			{"/feeds/events.atom", `<link href="https://protestpulse.test/feeds/events.atom" rel="self">`},
+			{"/feeds/events.atom", fmt.Sprintf(`<link href="https://protestpulse.test/events/%d" rel="alternate">`, e.ID)},
+		} {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Host = host
+			if body := ts.serve(r, nil).Body.String(); !strings.Contains(body, tt.want) {
+				t.Errorf("%s from %s does not contain %q", tt.path, host, tt.want)
+			}
+		}
+	}
+}
+
+func TestEmptyFeedIsDatedNow(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	before := time.Now().Add(-time.Second)
+	rec := ts.get("/feeds/topics/2.atom", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	var f struct {
+		Updated time.Time `xml:"updated"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Updated.Before(before) {
+		t.Errorf("updated = %v, want about now", f.Updated)
+	}
+	if lm := rec.Header().Get("Last-Modified"); lm != "" {
+		t.Errorf("Last-Modified = %q, want none", lm)
+	}
+}
+
+func TestEventsLocationFilterIsLiteral(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	ts.login("organizer", db.RoleOrganizer)
+	ts.createEvent("organizer", "Shared event")
+
+	for location, found := range map[string]bool{"city HALL": true, "%": false, "_": false, `\`: false} {
+		body := ts.get("/events?location="+url.QueryEscape(location), nil).Body.String()
+		if strings.Contains(body, "Shared event") != found {
+			t.Errorf("location %q: found = %v, want %v", location, !found, found)
+		}
+	}
+}
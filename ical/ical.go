@@ -0,0 +1,155 @@
+// Package ical writes calendars in the iCalendar format of RFC 5545.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// utcFormat is the layout of a DATE-TIME value in UTC.
+const utcFormat = "20060102T150405Z"
+
+// localFormat is the layout of a DATE-TIME value in the zone named by the
+// TZID parameter of its property.
+const localFormat = "20060102T150405"
+
+// maxLineOctets is the longest a content line may be before it is folded.
+const maxLineOctets = 75
+
+// Calendar is a VCALENDAR object holding a list of events.
+type Calendar struct {
+	// ProdID identifies the product that created the calendar.
+	ProdID string
+	// Name is shown by calendar apps as the name of a subscribed calendar.
+	Name   string
+	Events []Event
+}
+
+// Event is a VEVENT component.
+type Event struct {
+	// UID must be globally unique and stay the same when the event changes.
+	UID string
+	// Sequence must increase each time the event is changed so that
+	// calendar apps pick up the change.
+	Sequence     int
+	Stamp        time.Time
+	LastModified time.Time
+	// Start and End are written as local times in their location, with the
+	// IANA name of the zone as TZID, so that calendar apps keep the event at
+	// the same local time across daylight saving time changes. Apps resolve
+	// the name from their own zone database, so no VTIMEZONE is written.
+	// Times in UTC, or in a location without an IANA name, are written in
+	// UTC.
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+}
+
+// Encode writes the calendar to w.
+func (c *Calendar) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := &encoder{w: bw}
+
+	enc.line("BEGIN", "VCALENDAR")
+	enc.line("VERSION", "2.0")
+	enc.line("PRODID", c.ProdID)
+	enc.line("CALSCALE", "GREGORIAN")
+	enc.line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		enc.line("X-WR-CALNAME", escape(c.Name))
+	}
+	for _, e := range c.Events {
+		enc.line("BEGIN", "VEVENT")
+		enc.line("UID", escape(e.UID))
+		enc.line("SEQUENCE", fmt.Sprint(e.Sequence))
+		enc.line("DTSTAMP", formatTime(e.Stamp))
+		if !e.LastModified.IsZero() {
+			enc.line("LAST-MODIFIED", formatTime(e.LastModified))
+		}
+		enc.timeLine("DTSTART", e.Start)
+		if !e.End.IsZero() {
+			enc.timeLine("DTEND", e.End)
+		}
+		enc.line("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			enc.line("DESCRIPTION", escape(e.Description))
+		}
+		if e.Location != "" {
+			enc.line("LOCATION", escape(e.Location))
+		}
+		if e.URL != "" {
+			enc.line("URL", e.URL)
+		}
+		enc.line("END", "VEVENT")
+	}
+	enc.line("END", "VCALENDAR")
+
+	if enc.err != nil {
+		return enc.err
+	}
+	return bw.Flush()
+}
+
+// encoder writes folded content lines, remembering the first write error.
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+// line writes a content line, folding it so that no line is longer than
+// maxLineOctets without splitting a UTF-8 sequence.
+func (enc *encoder) line(name, value string) {
+	if enc.err != nil {
+		return
+	}
+	s := name + ":" + value
+	limit := maxLineOctets
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		if _, enc.err = enc.w.WriteString(s[:cut] + "\r\n "); enc.err != nil {
+			return
+		}
+		s = s[cut:]
+		// Continuation lines start with a space, which counts towards the limit.
+		limit = maxLineOctets - 1
+	}
+	_, enc.err = enc.w.WriteString(s + "\r\n")
+}
+
+// timeLine writes a DATE-TIME property, as a local time with a TZID
+// parameter unless t is in UTC or its location has no IANA name.
+func (enc *encoder) timeLine(name string, t time.Time) {
+	switch zone := t.Location().String(); zone {
+	case "UTC", "Local", "":
+		enc.line(name, formatTime(t))
+	default:
+		enc.line(name+";TZID="+zone, t.Format(localFormat))
+	}
+}
+
+// escape escapes a TEXT value.
+func escape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", `\n`,
+	).Replace(s)
+}
+
+// formatTime formats t as a DATE-TIME value in UTC.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(utcFormat)
+}
@@ -0,0 +1,39 @@
+package identity
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Auth0 is an OpenID Connect provider for an Auth0 tenant. Auth0 user ids
+// already name the connection they belong to, such as 'google-oauth2|123',
+// so they are used as is.
+type Auth0 struct {
+	*OIDC
+	domain string
+}
+
+// NewAuth0 returns a provider for the Auth0 tenant at c.Domain.
+func NewAuth0(c Config) (*Auth0, error) {
+	if c.Domain == "" {
+		return nil, fmt.Errorf("Identity provider %q has no domain", c.Name)
+	}
+	c.Issuer = fmt.Sprintf("https://%s/", c.Domain)
+
+	p, err := NewOIDC(c)
+	if err != nil {
+		return nil, err
+	}
+	p.subjectPrefix = ""
+	return &Auth0{OIDC: p, domain: c.Domain}, nil
+}
+
+// LogoutURL returns the URL of the Auth0 logout endpoint, which does not
+// implement OpenID Connect RP-initiated logout.
+func (p *Auth0) LogoutURL(ctx context.Context, idToken, returnTo string) (string, error) {
+	q := url.Values{}
+	q.Set("returnTo", returnTo)
+	q.Set("client_id", p.config.ClientID)
+	return fmt.Sprintf("https://%s/v2/logout?%s", p.domain, q.Encode()), nil
+}
@@ -0,0 +1,107 @@
+// Package identity signs users in with external OpenID Connect identity
+// providers, such as Auth0 or a self-hosted Keycloak.
+package identity
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/chloearianne/protestpulse/session"
+)
+
+// Provider types supported in Config.Type.
+const (
+	TypeOIDC  = "oidc"
+	TypeAuth0 = "auth0"
+)
+
+// Config contains the parameters of an identity provider. String values may
+// refer to environment variables as $VAR or ${VAR}, so that secrets can be
+// kept out of the config file.
+type Config struct {
+	// Name identifies the provider in URLs and sessions.
+	Name string `yaml:"name"`
+	// Type is either "oidc" or "auth0".
+	Type string `yaml:"type"`
+	// Label is shown to users on the login page.
+	Label string `yaml:"label"`
+	// Issuer is the issuer URL of an "oidc" provider, used for discovery.
+	Issuer string `yaml:"issuer"`
+	// Domain is the tenant domain of an "auth0" provider.
+	Domain       string   `yaml:"domain"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// Login is the result of a successful sign in.
+type Login struct {
+	Profile     *session.Profile
+	IDToken     string
+	AccessToken string
+}
+
+// Provider is an identity provider users can sign in with.
+type Provider interface {
+	// Name returns the name of the provider from its Config.
+	Name() string
+	// Label returns the name of the provider shown to users.
+	Label() string
+	// AuthCodeURL returns the URL to send the user to for signing in.
+	AuthCodeURL(ctx context.Context, state, nonce string) (string, error)
+	// Exchange verifies the authorization code returned to the callback
+	// and returns the user's profile.
+	Exchange(ctx context.Context, code, nonce string) (*Login, error)
+	// LogoutURL returns the URL to send the user to for signing out of the
+	// provider, after which they are sent back to returnTo. It returns an
+	// empty string if the provider does not support signing out.
+	LogoutURL(ctx context.Context, idToken, returnTo string) (string, error)
+}
+
+// New returns the Provider described by c.
+func New(c Config) (Provider, error) {
+	c = c.expandEnv()
+	if c.Name == "" {
+		return nil, fmt.Errorf("Identity provider has no name")
+	}
+	if c.Label == "" {
+		c.Label = c.Name
+	}
+
+	switch c.Type {
+	case TypeOIDC:
+		return NewOIDC(c)
+	case TypeAuth0:
+		return NewAuth0(c)
+	default:
+		return nil, fmt.Errorf("Identity provider %q has unknown type %q", c.Name, c.Type)
+	}
+}
+
+// NewProviders returns the Providers described by configs, in order.
+func NewProviders(configs []Config) ([]Provider, error) {
+	var providers []Provider
+	names := map[string]bool{}
+	for _, c := range configs {
+		p, err := New(c)
+		if err != nil {
+			return nil, err
+		}
+		if names[p.Name()] {
+			return nil, fmt.Errorf("Identity provider %q is configured twice", p.Name())
+		}
+		names[p.Name()] = true
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// expandEnv returns c with environment variables expanded.
+func (c Config) expandEnv() Config {
+	for _, s := range []*string{&c.Issuer, &c.Domain, &c.ClientID, &c.ClientSecret, &c.RedirectURL} {
+		*s = os.ExpandEnv(*s)
+	}
+	return c
+}
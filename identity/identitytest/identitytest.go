@@ -0,0 +1,272 @@
+// Package identitytest provides a mock OpenID Connect identity provider for
+// testing sign in without a real provider.
+package identitytest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chloearianne/protestpulse/identity"
+)
+
+// keyID is the id of the mock provider's signing key.
+const keyID = "identitytest"
+
+// User contains the claims the mock provider returns for the signed in user.
+type User struct {
+	Subject    string
+	Email      string
+	GivenName  string
+	FamilyName string
+	Picture    string
+}
+
+// Server is a mock OpenID Connect provider. Its authorization endpoint signs
+// in User without asking, and redirects straight back to the client.
+type Server struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+	User         User
+
+	// ModifyClaims, if set, is called with the claims of each ID token
+	// before it is signed, so tests can issue invalid tokens.
+	ModifyClaims func(claims map[string]interface{})
+
+	key *rsa.PrivateKey
+
+	mu            sync.Mutex
+	codes         map[string]grant
+	accessTokens  map[string]User
+	tokenRequests int
+}
+
+// grant is an authorization code issued by the mock provider.
+type grant struct {
+	redirectURI string
+	nonce       string
+	user        User
+}
+
+// NewServer starts and returns a new mock provider. The caller should call
+// Close when finished, to shut it down.
+func NewServer() *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Server{
+		ClientID:     "identitytest-client",
+		ClientSecret: "identitytest-secret",
+		User: User{
+			Subject:    "user-1",
+			Email:      "user@example.com",
+			GivenName:  "Test",
+			FamilyName: "User",
+		},
+		key:          key,
+		codes:        map[string]grant{},
+		accessTokens: map[string]User{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/userinfo", s.userinfo)
+	mux.HandleFunc("/logout", s.logout)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Config returns the configuration of an "oidc" provider named name for
+// the mock provider.
+func (s *Server) Config(name, redirectURL string) identity.Config {
+	return identity.Config{
+		Name:         name,
+		Type:         identity.TypeOIDC,
+		Label:        "Mock provider",
+		Issuer:       s.URL,
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+// TokenRequests returns the number of codes clients tried to exchange.
+func (s *Server) TokenRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenRequests
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"userinfo_endpoint":      s.URL + "/userinfo",
+		"jwks_uri":               s.URL + "/jwks",
+		"end_session_endpoint":   s.URL + "/logout",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encode(pub.N.Bytes()),
+			"e":   encode(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != s.ClientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = grant{redirectURI: q.Get("redirect_uri"), nonce: q.Get("nonce"), user: s.User}
+	s.mu.Unlock()
+
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.tokenRequests++
+	g, ok := s.codes[r.FormValue("code")]
+	delete(s.codes, r.FormValue("code"))
+	s.mu.Unlock()
+
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	if id != s.ClientID || secret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.FormValue("grant_type") != "authorization_code" || !ok || g.redirectURI != r.FormValue("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	accessToken := randomString()
+	s.mu.Lock()
+	s.accessTokens[accessToken] = g.user
+	s.mu.Unlock()
+
+	now := time.Now()
+	claims := userClaims(g.user)
+	claims["iss"] = s.URL
+	claims["aud"] = s.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	if s.ModifyClaims != nil {
+		s.ModifyClaims(claims)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     s.sign(claims),
+	})
+}
+
+func (s *Server) userinfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	u, ok := s.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "invalid_token", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, userClaims(u))
+}
+
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	if u := r.URL.Query().Get("post_logout_redirect_uri"); u != "" {
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	fmt.Fprintln(w, "Signed out")
+}
+
+// sign returns claims as an ID token signed with RS256.
+func (s *Server) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, _ := json.Marshal(claims)
+	signed := encode(header) + "." + encode(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + encode(sig)
+}
+
+func userClaims(u User) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":         u.Subject,
+		"email":       u.Email,
+		"given_name":  u.GivenName,
+		"family_name": u.FamilyName,
+		"picture":     u.Picture,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return encode(b)
+}
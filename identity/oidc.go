@@ -0,0 +1,250 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chloearianne/protestpulse/session"
+	"golang.org/x/oauth2"
+)
+
+// defaultScopes are requested when a Config lists none.
+var defaultScopes = []string{"openid", "profile", "email"}
+
+// httpTimeout bounds every request to a provider.
+const httpTimeout = 10 * time.Second
+
+// OIDC is a generic OpenID Connect provider. Its endpoints and signing keys
+// are discovered from the issuer URL, and ID tokens are verified against
+// the keys of the provider's JWKS document.
+//
+// User ids are the subject of the ID token prefixed with the provider name,
+// such as 'keycloak|0b7e...', so that users of different providers cannot
+// clash.
+type OIDC struct {
+	config        Config
+	subjectPrefix string
+	client        *http.Client
+	now           func() time.Time
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]crypto.PublicKey
+}
+
+// discovery is the OpenID Provider Metadata at
+// '{issuer}/.well-known/openid-configuration'.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// NewOIDC returns a provider for the OpenID Connect issuer at c.Issuer.
+// Discovery happens on first use, so the provider does not need to be
+// reachable when the app starts.
+func NewOIDC(c Config) (*OIDC, error) {
+	if c.Issuer == "" {
+		return nil, fmt.Errorf("Identity provider %q has no issuer", c.Name)
+	}
+	if c.ClientID == "" {
+		return nil, fmt.Errorf("Identity provider %q has no client_id", c.Name)
+	}
+	if c.RedirectURL == "" {
+		return nil, fmt.Errorf("Identity provider %q has no redirect_url", c.Name)
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = defaultScopes
+	}
+	if !hasScope(c.Scopes, "openid") {
+		c.Scopes = append([]string{"openid"}, c.Scopes...)
+	}
+
+	return &OIDC{
+		config:        c,
+		subjectPrefix: c.Name + "|",
+		client:        &http.Client{Timeout: httpTimeout},
+		now:           time.Now,
+	}, nil
+}
+
+// Name returns the name of the provider from its Config.
+func (p *OIDC) Name() string {
+	return p.config.Name
+}
+
+// Label returns the name of the provider shown to users.
+func (p *OIDC) Label() string {
+	return p.config.Label
+}
+
+// AuthCodeURL returns the URL of the provider's authorization endpoint.
+func (p *OIDC) AuthCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.oauth2Config(d).AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce)), nil
+}
+
+// Exchange trades the authorization code for tokens, verifies the ID token
+// and returns the profile it describes, completed from the userinfo
+// endpoint if the provider has one.
+func (p *OIDC) Exchange(ctx context.Context, code, nonce string) (*Login, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := p.oauth2Config(d).Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("Could not exchange code: %v", err)
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, fmt.Errorf("Token response has no id_token")
+	}
+
+	c, err := p.verifyIDToken(ctx, d, rawIDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.UserinfoEndpoint != "" && token.AccessToken != "" {
+		info, err := p.userinfo(ctx, d, token.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		if info.Subject != c.Subject {
+			return nil, fmt.Errorf("Userinfo subject %q does not match ID token subject %q", info.Subject, c.Subject)
+		}
+		c.merge(info)
+	}
+
+	return &Login{
+		Profile: &session.Profile{
+			UserID:     p.subjectPrefix + c.Subject,
+			Email:      c.Email,
+			GivenName:  c.GivenName,
+			FamilyName: c.FamilyName,
+			Picture:    c.Picture,
+		},
+		IDToken:     rawIDToken,
+		AccessToken: token.AccessToken,
+	}, nil
+}
+
+// LogoutURL returns the URL of the provider's end session endpoint, if it
+// has one.
+func (p *OIDC) LogoutURL(ctx context.Context, idToken, returnTo string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	if d.EndSessionEndpoint == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(d.EndSessionEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if idToken != "" {
+		q.Set("id_token_hint", idToken)
+	}
+	q.Set("post_logout_redirect_uri", returnTo)
+	q.Set("client_id", p.config.ClientID)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// oauth2Config returns the OAuth 2.0 configuration for the endpoints of d.
+func (p *OIDC) oauth2Config(d *discovery) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Scopes:       p.config.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  d.AuthorizationEndpoint,
+			TokenURL: d.TokenEndpoint,
+		},
+	}
+}
+
+// discover returns the provider metadata, fetching it on first use.
+func (p *OIDC) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d discovery
+	u := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, u, "", &d); err != nil {
+		return nil, fmt.Errorf("Could not discover identity provider %q: %v", p.config.Name, err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != strings.TrimSuffix(p.config.Issuer, "/") {
+		return nil, fmt.Errorf("Identity provider %q reports issuer %q, expected %q", p.config.Name, d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("Identity provider %q is missing required endpoints", p.config.Name)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// userinfo returns the claims of the userinfo endpoint for accessToken.
+func (p *OIDC) userinfo(ctx context.Context, d *discovery, accessToken string) (*claims, error) {
+	var c claims
+	if err := p.getJSON(ctx, d.UserinfoEndpoint, accessToken, &c); err != nil {
+		return nil, fmt.Errorf("Could not get userinfo: %v", err)
+	}
+	return &c, nil
+}
+
+// getJSON decodes the JSON document at u into v, authenticating with
+// accessToken if it is set.
+func (p *OIDC) getJSON(ctx context.Context, u, accessToken string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package identity_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chloearianne/protestpulse/identity"
+	"github.com/chloearianne/protestpulse/identity/identitytest"
+)
+
+const redirectURL = "http://app.example/auth/callback"
+
+// signIn follows the authorization URL of p and returns the code and state
+// the provider redirects back with.
+func signIn(t *testing.T, p identity.Provider, state, nonce string) (code, gotState string) {
+	u, err := p.AuthCodeURL(context.Background(), state, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc.String(), redirectURL) {
+		t.Fatalf("redirected to %q, want %q", loc, redirectURL)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestOIDCExchange(t *testing.T) {
+	idp := identitytest.NewServer()
+	defer idp.Close()
+
+	p, err := identity.New(idp.Config("mock", redirectURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state := signIn(t, p, "state-1", "nonce-1")
+	if state != "state-1" {
+		t.Errorf("state = %q, want %q", state, "state-1")
+	}
+
+	login, err := p.Exchange(context.Background(), code, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "mock|" + idp.User.Subject; login.Profile.UserID != want {
+		t.Errorf("UserID = %q, want %q", login.Profile.UserID, want)
+	}
+	if login.Profile.Email != idp.User.Email || login.Profile.GivenName != idp.User.GivenName {
+		t.Errorf("Profile = %+v, want claims of %+v", login.Profile, idp.User)
+	}
+	if login.IDToken == "" || login.AccessToken == "" {
+		t.Error("Login is missing tokens")
+	}
+
+	// Codes can only be used once.
+	if _, err = p.Exchange(context.Background(), code, "nonce-1"); err == nil {
+		t.Error("exchanging a code twice succeeded")
+	}
+}
+
+func TestOIDCExchangeRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		nonce  string
+		modify func(claims map[string]interface{})
+	}{
+		{"wrong nonce", "other-nonce", nil},
+		{"wrong audience", "nonce", func(c map[string]interface{}) { c["aud"] = "other-client" }},
+		{"wrong issuer", "nonce", func(c map[string]interface{}) { c["iss"] = "https://evil.example" }},
+		{"expired", "nonce", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"issued in the future", "nonce", func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{"no subject", "nonce", func(c map[string]interface{}) { delete(c, "sub") }},
+		{"multiple audiences without azp", "nonce", func(c map[string]interface{}) {
+			c["aud"] = []string{c["aud"].(string), "other-client"}
+		}},
+	}
+	for _, tt := range tests {
+		idp := identitytest.NewServer()
+		idp.ModifyClaims = tt.modify
+		p, err := identity.New(idp.Config("mock", redirectURL))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		code, _ := signIn(t, p, "state", "nonce")
+		if _, err = p.Exchange(context.Background(), code, tt.nonce); err == nil {
+			t.Errorf("%s: Exchange succeeded", tt.name)
+		}
+		idp.Close()
+	}
+}
+
+func TestOIDCLogoutURL(t *testing.T) {
+	idp := identitytest.NewServer()
+	defer idp.Close()
+
+	p, err := identity.New(idp.Config("mock", redirectURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := p.LogoutURL(context.Background(), "id-token", "http://app.example/auth/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u, idp.URL+"/logout?") || !strings.Contains(u, "id_token_hint=id-token") {
+		t.Errorf("LogoutURL = %q", u)
+	}
+}
+
+func TestNewProviders(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []identity.Config
+		ok      bool
+	}{
+		{"oidc and auth0", []identity.Config{
+			{Name: "keycloak", Type: "oidc", Issuer: "http://localhost:8080/realms/pp", ClientID: "pp", RedirectURL: redirectURL},
+			{Name: "auth0", Type: "auth0", Domain: "pp.auth0.com", ClientID: "pp", RedirectURL: redirectURL},
+		}, true},
+		{"duplicate names", []identity.Config{
+			{Name: "a", Type: "auth0", Domain: "pp.auth0.com", ClientID: "pp", RedirectURL: redirectURL},
+			{Name: "a", Type: "auth0", Domain: "pp.auth0.com", ClientID: "pp", RedirectURL: redirectURL},
+		}, false},
+		{"unknown type", []identity.Config{{Name: "a", Type: "saml"}}, false},
+		{"missing issuer", []identity.Config{{Name: "a", Type: "oidc", ClientID: "pp", RedirectURL: redirectURL}}, false},
+		{"missing name", []identity.Config{{Type: "auth0", Domain: "pp.auth0.com", ClientID: "pp", RedirectURL: redirectURL}}, false},
+	}
+	for _, tt := range tests {
+		_, err := identity.NewProviders(tt.configs)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/importer"
+)
+
+// maxImportSize is the largest import file accepted, in bytes.
+const maxImportSize = 1 << 20
+
+// ImportGET handles GET requests for '/events/import' by showing the
+// upload form.
+func (a *App) ImportGET(w http.ResponseWriter, r *http.Request) {
+	topics, types, err := a.lookups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Page":   "Import",
+		"Topics": topics,
+		"Types":  types,
+	}
+	a.renderTemplate(w, r, "import.tmpl", data)
+}
+
+// ImportPOST handles POST requests for '/events/import'. The uploaded file
+// is always parsed and shown as a preview with the problems of each row.
+// Only if the 'import' action was chosen and every row is valid are the
+// events created, all in a single transaction.
+func (a *App) ImportPOST(w http.ResponseWriter, r *http.Request) {
+	p := requestProfile(r)
+
+	topics, types, err := a.lookups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename, content, err := importFile(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := importer.FormatOf(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Imported events take place in the importing user's time zone.
+	zone := a.viewerZone(r)
+	defaultTopic, defaultType := r.FormValue("default_topic"), r.FormValue("default_type")
+	rows, err := importer.Parse(bytes.NewReader(content), format, a.importOptions(zone, topics, types, defaultTopic, defaultType))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invalid := 0
+	for _, row := range rows {
+		if !row.Valid() {
+			invalid++
+		}
+	}
+
+	data := map[string]interface{}{
+		"Page":         "Import",
+		"Topics":       topics,
+		"Types":        types,
+		"Rows":         rows,
+		"Invalid":      invalid,
+		"Filename":     filename,
+		"Content":      base64.StdEncoding.EncodeToString(content),
+		"DefaultTopic": defaultTopic,
+		"DefaultType":  defaultType,
+	}
+
+	if r.FormValue("action") == "import" && invalid == 0 && len(rows) > 0 {
+		if err = a.importRows(p.UserID, zone, rows); err != nil {
+			logrus.WithError(err).Error("Failed to import events")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/events", http.StatusSeeOther)
+		return
+	}
+
+	a.renderTemplate(w, r, "import.tmpl", data)
+}
+
+// importFile returns the name and content of the file to import, either
+// uploaded as 'file' or, when confirming a preview, sent back base64
+// encoded as 'content' along with its 'filename'.
+func importFile(w http.ResponseWriter, r *http.Request) (string, []byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil && err != http.ErrNotMultipart {
+		return "", nil, err
+	}
+
+	if encoded := r.FormValue("content"); encoded != "" {
+		content, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", nil, fmt.Errorf("Invalid file content: %v", err)
+		}
+		return r.FormValue("filename"), content, nil
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return "", nil, fmt.Errorf("Please choose a file to import")
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(file, maxImportSize+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(content) > maxImportSize {
+		return "", nil, fmt.Errorf("The file must be at most %d KB", maxImportSize>>10)
+	}
+	return header.Filename, content, nil
+}
+
+// importOptions returns the options for parsing an import file of events
+// taking place in zone, resolving topic and type names against the given
+// lookups.
+func (a *App) importOptions(zone *time.Location, topics, types []db.Lookup, defaultTopic, defaultType string) importer.Options {
+	return importer.Options{
+		Location:     zone,
+		DefaultTopic: defaultTopic,
+		DefaultType:  defaultType,
+		Topics:       lookupIDs(topics),
+		Types:        lookupIDs(types),
+	}
+}
+
+// importRows creates an event owned by creatorID and taking place in zone
+// for each of rows, all in a single transaction. The rows must be valid.
+func (a *App) importRows(creatorID string, zone *time.Location, rows []*importer.Row) error {
+	events := make([]*db.Event, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, &db.Event{
+			CreatorID:   creatorID,
+			Title:       row.Title,
+			Start:       row.Start,
+			End:         row.End,
+			TimeZone:    zone.String(),
+			Description: row.Description,
+			Type:        row.TypeID,
+			Topic:       row.TopicID,
+			Location:    row.Location,
+		})
+	}
+	return a.store.CreateEvents(events)
+}
+
+// lookupIDs maps the lower case names of lookups to their ids.
+func lookupIDs(lookups []db.Lookup) map[string]int {
+	ids := make(map[string]int, len(lookups))
+	for _, l := range lookups {
+		ids[strings.ToLower(l.Name)] = l.ID
+	}
+	return ids
+}
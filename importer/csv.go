@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// csvColumns maps the accepted CSV header names, in lower case, to the
+// event field they hold.
+var csvColumns = map[string]string{
+	"title":       "title",
+	"name":        "title",
+	"summary":     "title",
+	"description": "description",
+	"details":     "description",
+	"location":    "location",
+	"where":       "location",
+	"topic":       "topic",
+	"category":    "topic",
+	"event_topic": "topic",
+	"type":        "type",
+	"event_type":  "type",
+	"start":       "start",
+	"start_time":  "start_time",
+	"start_date":  "start_date",
+	"end":         "end",
+	"end_time":    "end_time",
+	"end_date":    "end_date",
+}
+
+// csvTimeFormats are the layouts accepted for start and end columns.
+var csvTimeFormats = []string{
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	"01/02/2006 15:04",
+	"01/02/2006 3:04 PM",
+	"1/2/2006 15:04",
+	"1/2/2006 3:04 PM",
+}
+
+// parseCSV reads a CSV file whose first line names its columns. Columns
+// with unknown names are ignored. Start and end times may be given either in
+// start and end columns or split into date and time columns.
+func parseCSV(r io.Reader, loc *time.Location) ([]*Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("The CSV file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, len(header))
+	known := false
+	for i, name := range header {
+		fields[i] = csvColumns[strings.ToLower(strings.TrimSpace(name))]
+		known = known || fields[i] != ""
+	}
+	if !known {
+		return nil, fmt.Errorf("The CSV header names none of the event columns")
+	}
+
+	var rows []*Row
+	// The header is line 1.
+	line := 1
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, err
+		}
+
+		values := map[string]string{}
+		for i, v := range record {
+			if i < len(fields) && fields[i] != "" {
+				values[fields[i]] = strings.TrimSpace(v)
+			}
+		}
+		row := &Row{
+			Line:        line,
+			Title:       values["title"],
+			Description: values["description"],
+			Location:    values["location"],
+			Topic:       values["topic"],
+			Type:        values["type"],
+		}
+		row.Start = csvTime(row, "start", values, loc)
+		row.End = csvTime(row, "end", values, loc)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// csvTime reads the start or end time of a row, recording an error on the
+// row if it cannot be parsed.
+func csvTime(row *Row, name string, values map[string]string, loc *time.Location) time.Time {
+	v := values[name]
+	if v == "" && values[name+"_date"] != "" {
+		v = strings.TrimSpace(values[name+"_date"] + " " + values[name+"_time"])
+	}
+	if v == "" {
+		return time.Time{}
+	}
+	for _, layout := range csvTimeFormats {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t
+		}
+	}
+	row.errorf("Invalid %s time %q, expected YYYY-MM-DD HH:MM", name, v)
+	return time.Time{}
+}
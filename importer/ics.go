@@ -0,0 +1,196 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// icsProperty is a content line of an iCalendar file.
+type icsProperty struct {
+	line   int
+	name   string
+	params map[string]string
+	value  string
+}
+
+// parseICS reads the VEVENT components of an iCalendar file. The first
+// CATEGORIES value of an event is used as its topic, and X-EVENT-TYPE as
+// its type.
+func parseICS(r io.Reader, loc *time.Location) ([]*Row, error) {
+	props, err := readICS(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []*Row
+	var row *Row
+	for _, p := range props {
+		switch {
+		case p.name == "BEGIN" && strings.EqualFold(p.value, "VEVENT"):
+			row = &Row{Line: p.line}
+		case p.name == "END" && strings.EqualFold(p.value, "VEVENT"):
+			if row != nil {
+				rows = append(rows, row)
+			}
+			row = nil
+		case row == nil:
+			// Properties outside of events, such as those of the calendar
+			// or its time zones, are ignored.
+		case p.name == "SUMMARY":
+			row.Title = unescapeICS(p.value)
+		case p.name == "DESCRIPTION":
+			row.Description = unescapeICS(p.value)
+		case p.name == "LOCATION":
+			row.Location = unescapeICS(p.value)
+		case p.name == "CATEGORIES":
+			if row.Topic == "" {
+				row.Topic = unescapeICS(strings.SplitN(p.value, ",", 2)[0])
+			}
+		case p.name == "X-EVENT-TYPE":
+			row.Type = unescapeICS(p.value)
+		case p.name == "DTSTART":
+			row.Start = icsTime(row, p, loc)
+		case p.name == "DTEND":
+			row.End = icsTime(row, p, loc)
+		}
+	}
+	if row != nil {
+		return nil, fmt.Errorf("Line %d: event is missing END:VEVENT", row.Line)
+	}
+	return rows, nil
+}
+
+// readICS reads the unfolded content lines of an iCalendar file.
+func readICS(r io.Reader) ([]icsProperty, error) {
+	var props []icsProperty
+	var cur *icsProperty
+	var raw string
+
+	flush := func() error {
+		if cur == nil {
+			return nil
+		}
+		p, err := parseICSLine(cur.line, raw)
+		if err != nil {
+			return err
+		}
+		props = append(props, p)
+		cur = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		// Lines starting with whitespace continue the previous line.
+		if cur != nil && (text[0] == ' ' || text[0] == '\t') {
+			raw += text[1:]
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		cur = &icsProperty{line: line}
+		raw = text
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if len(props) == 0 || props[0].name != "BEGIN" || !strings.EqualFold(props[0].value, "VCALENDAR") {
+		return nil, fmt.Errorf("The file is not an iCalendar file")
+	}
+	return props, nil
+}
+
+// parseICSLine splits an unfolded content line into its name, parameters
+// and value.
+func parseICSLine(line int, raw string) (icsProperty, error) {
+	p := icsProperty{line: line, params: map[string]string{}}
+	// The value starts at the first colon outside of a quoted parameter value.
+	quoted := false
+	colon := -1
+	for i, c := range raw {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return p, fmt.Errorf("Line %d: invalid content line %q", line, raw)
+	}
+	p.value = raw[colon+1:]
+
+	parts := strings.Split(raw[:colon], ";")
+	p.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			p.params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return p, nil
+}
+
+// icsTime parses a DTSTART or DTEND property, recording an error on the row
+// if it cannot be parsed. UTC times and times with a TZID are converted to
+// loc, floating times are read in loc, and dates start at midnight.
+func icsTime(row *Row, p icsProperty, loc *time.Location) time.Time {
+	v := p.value
+	if p.params["VALUE"] == "DATE" || len(v) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", v, loc)
+		if err != nil {
+			row.errorf("Invalid %s %q", p.name, v)
+			return time.Time{}
+		}
+		return t
+	}
+
+	if strings.HasSuffix(v, "Z") {
+		t, err := time.Parse("20060102T150405Z", v)
+		if err != nil {
+			row.errorf("Invalid %s %q", p.name, v)
+			return time.Time{}
+		}
+		return t.In(loc)
+	}
+
+	in := loc
+	if tzid := p.params["TZID"]; tzid != "" {
+		var err error
+		if in, err = time.LoadLocation(tzid); err != nil {
+			row.errorf("Unknown time zone %q in %s", tzid, p.name)
+			return time.Time{}
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", v, in)
+	if err != nil {
+		row.errorf("Invalid %s %q", p.name, v)
+		return time.Time{}
+	}
+	return t.In(loc)
+}
+
+// unescapeICS reverses the escaping of a TEXT value.
+func unescapeICS(s string) string {
+	return strings.NewReplacer(
+		`\\`, `\`,
+		`\;`, ";",
+		`\,`, ",",
+		`\n`, "\n",
+		`\N`, "\n",
+	).Replace(s)
+}
@@ -0,0 +1,154 @@
+// Package importer parses events from iCalendar and CSV files so that they
+// can be bulk imported.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chloearianne/protestpulse/db"
+)
+
+// Row is an event read from an import file, along with any problems that
+// prevent it from being imported.
+type Row struct {
+	// Line is the line of the file the row starts on.
+	Line        int
+	Title       string
+	Description string
+	Location    string
+	// Topic and Type are the names of the event_topic and event_type rows
+	// of the event, and TopicID and TypeID their ids once resolved.
+	Topic   string
+	Type    string
+	TopicID int
+	TypeID  int
+	Start   time.Time
+	End     time.Time
+	Errors  []string
+}
+
+// Valid reports whether the row can be imported.
+func (r *Row) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *Row) errorf(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// Format is a supported import file format.
+type Format string
+
+// Supported import formats.
+const (
+	CSV Format = "csv"
+	ICS Format = "ics"
+)
+
+// FormatOf guesses the format of a file from its name.
+func FormatOf(filename string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return CSV, nil
+	case ".ics", ".ical", ".ifb", ".icalendar":
+		return ICS, nil
+	}
+	return "", fmt.Errorf("Unsupported file type %q, expected .csv or .ics", filepath.Ext(filename))
+}
+
+// Options control how rows are read and validated.
+type Options struct {
+	// Location is the time zone the imported events take place in. Times
+	// without a zone are read in this zone, and other times are converted
+	// to it.
+	Location *time.Location
+	// DefaultTopic and DefaultType are used for rows that do not name one.
+	DefaultTopic string
+	DefaultType  string
+	// Topics and Types map lookup names, in lower case, to their ids.
+	Topics map[string]int
+	Types  map[string]int
+}
+
+// Parse reads the rows of an import file in the given format and validates
+// them. Errors that affect a single row are recorded on the row, while an
+// error is returned only if the file cannot be read at all.
+func Parse(r io.Reader, format Format, opts Options) ([]*Row, error) {
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+
+	var rows []*Row
+	var err error
+	switch format {
+	case CSV:
+		rows, err = parseCSV(r, opts.Location)
+	case ICS:
+		rows, err = parseICS(r, opts.Location)
+	default:
+		return nil, fmt.Errorf("Unsupported format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		validate(row, opts)
+	}
+	return rows, nil
+}
+
+// validate checks the fields of a row and resolves its topic and type.
+func validate(row *Row, opts Options) {
+	if row.Title == "" {
+		row.errorf("Title is required")
+	} else if utf8.RuneCountInString(row.Title) > db.MaxTitleLength {
+		row.errorf("Title must be at most %d characters", db.MaxTitleLength)
+	}
+	if row.Location == "" {
+		row.errorf("Location is required")
+	} else if utf8.RuneCountInString(row.Location) > db.MaxLocationLength {
+		row.errorf("Location must be at most %d characters", db.MaxLocationLength)
+	}
+	if utf8.RuneCountInString(row.Description) > db.MaxDescriptionLength {
+		row.errorf("Description must be at most %d characters", db.MaxDescriptionLength)
+	}
+
+	if row.Start.IsZero() {
+		row.errorf("Start time is required")
+	}
+	if row.End.IsZero() {
+		row.errorf("End time is required")
+	}
+	if !row.Start.IsZero() && !row.End.IsZero() && !row.End.After(row.Start) {
+		row.errorf("End time must be after the start time")
+	}
+
+	if row.Topic == "" {
+		row.Topic = opts.DefaultTopic
+	}
+	if row.Type == "" {
+		row.Type = opts.DefaultType
+	}
+	row.TopicID = resolve(row, "topic", row.Topic, opts.Topics)
+	row.TypeID = resolve(row, "type", row.Type, opts.Types)
+}
+
+// resolve looks up the id of a topic or type name, recording an error on
+// the row if it is missing or unknown.
+func resolve(row *Row, kind, name string, ids map[string]int) int {
+	if name == "" {
+		row.errorf("The %s is required", kind)
+		return 0
+	}
+	id, ok := ids[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		row.errorf("Unknown %s %q", kind, name)
+	}
+	return id
+}
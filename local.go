@@ -0,0 +1,357 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/identity"
+	"github.com/chloearianne/protestpulse/session"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// localProvider is the provider name stored in the auth-session of users
+// logged in with a local account.
+const localProvider = "local"
+
+// Limits of local account passwords. bcrypt only uses the first 72 bytes of
+// a password, so longer ones are refused rather than silently truncated.
+const (
+	minPasswordLength = 10
+	maxPasswordBytes  = 72
+)
+
+// bcryptCost is the cost of the bcrypt hashes of local account passwords.
+const bcryptCost = 12
+
+// After maxFailedLogins failed logins in a row, a local account is locked
+// for lockoutDuration.
+const (
+	maxFailedLogins = 5
+	lockoutDuration = 15 * time.Minute
+)
+
+// passwordResetExpiry is how long an emailed password reset link works.
+const passwordResetExpiry = time.Hour
+
+// errInvalidLogin is shown for both unknown email addresses and wrong
+// passwords, so that logins do not reveal who has an account.
+const errInvalidLogin = "Invalid email address or password"
+
+// dummyHash is compared against on logins to unknown email addresses, so
+// that they take as long as logins to existing accounts.
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// LocalLoginPOST handles POST requests for '/auth/local/login' by logging
+// in with the email address and password of a local account.
+func (a *App) LocalLoginPOST(w http.ResponseWriter, r *http.Request) {
+	if !a.localAccounts {
+		http.NotFound(w, r)
+		return
+	}
+
+	email := normalizeEmail(r.FormValue("email"))
+	password := r.FormValue("password")
+
+	account, err := a.store.LocalAccountByEmail(email)
+	if err == sql.ErrNoRows {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcryptCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		a.renderLogin(w, r, http.StatusUnauthorized, email, errInvalidLogin)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get local account")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	match := bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) == nil
+	if account.Locked(time.Now()) {
+		a.renderLogin(w, r, http.StatusTooManyRequests, email,
+			"This account is locked after too many failed logins. Try again later or reset your password.")
+		return
+	}
+	if !match {
+		if _, err = a.store.RecordFailedLogin(account.ID, maxFailedLogins, lockoutDuration); err != nil {
+			logrus.WithError(err).Error("Failed to record failed login")
+		}
+		a.renderLogin(w, r, http.StatusUnauthorized, email, errInvalidLogin)
+		return
+	}
+
+	if account.FailedLogins > 0 {
+		if err = a.store.ResetFailedLogins(account.ID); err != nil {
+			logrus.WithError(err).Error("Failed to reset failed logins")
+		}
+	}
+	a.logInLocal(w, r, account)
+}
+
+// SignupGET handles GET requests for '/auth/local/signup'.
+func (a *App) SignupGET(w http.ResponseWriter, r *http.Request) {
+	if !a.localAccounts {
+		http.NotFound(w, r)
+		return
+	}
+	a.renderTemplate(w, r, "signup.tmpl", map[string]interface{}{"Page": "Sign Up"})
+}
+
+// SignupPOST handles POST requests for '/auth/local/signup' by creating a
+// local account and logging in with it.
+func (a *App) SignupPOST(w http.ResponseWriter, r *http.Request) {
+	if !a.localAccounts {
+		http.NotFound(w, r)
+		return
+	}
+
+	account := &db.LocalAccount{
+		Email:      normalizeEmail(r.FormValue("email")),
+		GivenName:  strings.TrimSpace(r.FormValue("given_name")),
+		FamilyName: strings.TrimSpace(r.FormValue("family_name")),
+	}
+	data := map[string]interface{}{
+		"Page":       "Sign Up",
+		"Email":      account.Email,
+		"GivenName":  account.GivenName,
+		"FamilyName": account.FamilyName,
+	}
+
+	password := r.FormValue("password")
+	msg := validateEmail(account.Email)
+	if msg == "" {
+		msg = validatePassword(password, r.FormValue("confirm_password"))
+	}
+	if msg != "" {
+		data["Error"] = msg
+		a.renderTemplateStatus(w, r, http.StatusBadRequest, "signup.tmpl", data)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	account.PasswordHash = string(hash)
+
+	err = a.store.CreateLocalAccount(account)
+	if err == db.ErrEmailTaken {
+		data["Error"] = err.Error()
+		a.renderTemplateStatus(w, r, http.StatusConflict, "signup.tmpl", data)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to create local account")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a.logInLocal(w, r, account)
+}
+
+// ForgotPasswordGET handles GET requests for '/auth/local/forgot'.
+func (a *App) ForgotPasswordGET(w http.ResponseWriter, r *http.Request) {
+	if !a.localAccounts {
+		http.NotFound(w, r)
+		return
+	}
+	a.renderTemplate(w, r, "forgot.tmpl", map[string]interface{}{"Page": "Reset Password"})
+}
+
+// ForgotPasswordPOST handles POST requests for '/auth/local/forgot' by
+// emailing a password reset link to the account with the given email
+// address. The response is the same whether or not there is one.
+func (a *App) ForgotPasswordPOST(w http.ResponseWriter, r *http.Request) {
+	if !a.localAccounts {
+		http.NotFound(w, r)
+		return
+	}
+
+	email := normalizeEmail(r.FormValue("email"))
+	account, err := a.store.LocalAccountByEmail(email)
+	if err != nil && err != sql.ErrNoRows {
+		logrus.WithError(err).Error("Failed to get local account")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err == nil {
+		if err = a.sendPasswordReset(account); err != nil {
+			logrus.WithError(err).Error("Failed to send password reset")
+			http.Error(w, "Could not send the password reset email, please try again later", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data := map[string]interface{}{
+		"Page":  "Reset Password",
+		"Sent":  true,
+		"Email": email,
+	}
+	a.renderTemplate(w, r, "forgot.tmpl", data)
+}
+
+// ResetPasswordGET handles GET requests for '/auth/local/reset' from the
+// link of a password reset email.
+func (a *App) ResetPasswordGET(w http.ResponseWriter, r *http.Request) {
+	if !a.localAccounts {
+		http.NotFound(w, r)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	data := map[string]interface{}{
+		"Page":  "Reset Password",
+		"Token": token,
+	}
+	_, err := a.store.PasswordResetAccount(hashToken(token))
+	if err == sql.ErrNoRows {
+		data["Expired"] = true
+		a.renderTemplateStatus(w, r, http.StatusNotFound, "reset.tmpl", data)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get password reset")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.renderTemplate(w, r, "reset.tmpl", data)
+}
+
+// ResetPasswordPOST handles POST requests for '/auth/local/reset' by
+// setting a new password and logging in.
+func (a *App) ResetPasswordPOST(w http.ResponseWriter, r *http.Request) {
+	if !a.localAccounts {
+		http.NotFound(w, r)
+		return
+	}
+
+	token := r.FormValue("token")
+	data := map[string]interface{}{
+		"Page":  "Reset Password",
+		"Token": token,
+	}
+
+	password := r.FormValue("password")
+	if msg := validatePassword(password, r.FormValue("confirm_password")); msg != "" {
+		data["Error"] = msg
+		a.renderTemplateStatus(w, r, http.StatusBadRequest, "reset.tmpl", data)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	account, err := a.store.ResetPassword(hashToken(token), string(hash))
+	if err == sql.ErrNoRows {
+		data["Expired"] = true
+		a.renderTemplateStatus(w, r, http.StatusNotFound, "reset.tmpl", data)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to reset password")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a.logInLocal(w, r, account)
+}
+
+// sendPasswordReset emails a password reset link to the account. The link
+// is on the app's domain rather than the requested host, so that a forged
+// Host header cannot send the token elsewhere.
+func (a *App) sendPasswordReset(account *db.LocalAccount) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	if err = a.store.CreatePasswordReset(account.ID, hashToken(token), time.Now().Add(passwordResetExpiry)); err != nil {
+		return err
+	}
+
+	link := a.canonicalURL("/auth/local/reset?token=" + url.QueryEscape(token))
+	body := fmt.Sprintf("Someone asked to reset the password of your Protest Pulse account.\n\n"+
+		"To choose a new password, open this link within %d minutes:\n\n%s\n\n"+
+		"If you did not ask for this, you can ignore this email.\n",
+		int(passwordResetExpiry.Minutes()), link)
+	return a.mailer.Send(account.Email, "Reset your Protest Pulse password", body)
+}
+
+// logInLocal logs in with the local account, producing the same kind of
+// profile as logins through identity providers.
+func (a *App) logInLocal(w http.ResponseWriter, r *http.Request, account *db.LocalAccount) {
+	login := &identity.Login{
+		Profile: &session.Profile{
+			UserID:     account.UserID(),
+			Email:      account.Email,
+			GivenName:  account.GivenName,
+			FamilyName: account.FamilyName,
+		},
+	}
+
+	session, err := a.sessionStore.Get(r, "auth-session")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.logIn(w, r, session, login, localProvider)
+}
+
+// renderLogin renders the login page with an error for the local account
+// login form.
+func (a *App) renderLogin(w http.ResponseWriter, r *http.Request, status int, email, msg string) {
+	data := map[string]interface{}{
+		"Page":          "Login",
+		"Providers":     a.providers,
+		"LocalAccounts": a.localAccounts,
+		"Email":         email,
+		"Error":         msg,
+	}
+	a.renderTemplateStatus(w, r, status, "login.tmpl", data)
+}
+
+// normalizeEmail returns the form in which email addresses of local
+// accounts are stored.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validateEmail returns a message describing what is wrong with the email
+// address of a new account, or an empty string if it is valid.
+func validateEmail(email string) string {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > 254 {
+		return "Please enter a valid email address"
+	}
+	return ""
+}
+
+// validatePassword returns a message describing what is wrong with a new
+// password, or an empty string if it is valid.
+func validatePassword(password, confirm string) string {
+	switch {
+	case utf8.RuneCountInString(password) < minPasswordLength:
+		return fmt.Sprintf("Passwords must be at least %d characters long", minPasswordLength)
+	case len(password) > maxPasswordBytes:
+		return fmt.Sprintf("Passwords must be at most %d bytes long", maxPasswordBytes)
+	case password != confirm:
+		return "The passwords do not match"
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/chloearianne/protestpulse/session"
+)
+
+// resetLink matches the path of the link in password reset emails.
+var resetLink = regexp.MustCompile(`/auth/local/reset\?token=[\w-]+`)
+
+func TestLocalAccountFlow(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	visitor := ts.anonymous()
+
+	signup := url.Values{
+		"email":            {"Ada@Example.com "},
+		"given_name":       {"Ada"},
+		"family_name":      {"Lovelace"},
+		"password":         {"first password"},
+		"confirm_password": {"first password"},
+	}
+	rec := ts.submit("POST", "/auth/local/signup", signup, visitor)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/" {
+		t.Fatalf("signup: status = %d, redirected to %q, body %q", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	p, _ := sessionValue(t, ts.app, rec, "profile").(*session.Profile)
+	if p == nil || p.Email != "ada@example.com" {
+		t.Fatalf("profile after signup = %+v", p)
+	}
+	if _, err := ts.app.store.GetUser(p.UserID); err != nil {
+		t.Errorf("user was not saved: %v", err)
+	}
+
+	if rec := ts.submit("POST", "/auth/local/signup", signup, visitor); rec.Code != http.StatusConflict {
+		t.Errorf("second signup: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	login := func(password string) int {
+		form := url.Values{"email": {"ada@example.com"}, "password": {password}}
+		return ts.submit("POST", "/auth/local/login", form, visitor).Code
+	}
+	if status := login("wrong password"); status != http.StatusUnauthorized {
+		t.Errorf("login with wrong password: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := login("first password"); status != http.StatusSeeOther {
+		t.Errorf("login: status = %d, want %d", status, http.StatusSeeOther)
+	}
+
+	// Reset the password with the emailed link.
+	if rec := ts.submit("POST", "/auth/local/forgot", url.Values{"email": {"ada@example.com"}}, visitor); rec.Code != http.StatusOK {
+		t.Fatalf("forgot: status = %d", rec.Code)
+	}
+	email, ok := ts.mailer.last()
+	if !ok || email.To != "ada@example.com" {
+		t.Fatalf("reset email = %+v, sent = %v", email, ok)
+	}
+	link := resetLink.FindString(email.Body)
+	if link == "" {
+		t.Fatalf("no reset link in %q", email.Body)
+	}
+	if rec := ts.get(link, visitor); rec.Code != http.StatusOK {
+		t.Fatalf("reset page: status = %d", rec.Code)
+	}
+	token := link[len("/auth/local/reset?token="):]
+	reset := url.Values{"token": {token}, "password": {"second password"}, "confirm_password": {"second password"}}
+	if rec := ts.submit("POST", "/auth/local/reset", reset, visitor); rec.Code != http.StatusSeeOther {
+		t.Fatalf("reset: status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	if rec := ts.submit("POST", "/auth/local/reset", reset, visitor); rec.Code != http.StatusNotFound {
+		t.Errorf("second reset with the same link: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	if status := login("first password"); status != http.StatusUnauthorized {
+		t.Errorf("login with old password: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := login("second password"); status != http.StatusSeeOther {
+		t.Errorf("login with new password: status = %d, want %d", status, http.StatusSeeOther)
+	}
+}
+
+func TestPasswordResetLinkIgnoresHost(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	visitor := ts.anonymous()
+
+	signup := url.Values{
+		"email":            {"ada@example.com"},
+		"password":         {"first password"},
+		"confirm_password": {"first password"},
+	}
+	if rec := ts.submit("POST", "/auth/local/signup", signup, visitor); rec.Code != http.StatusSeeOther {
+		t.Fatalf("signup: status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	r := formRequest("POST", "/auth/local/forgot", url.Values{"email": {"ada@example.com"}})
+	r.Host = "attacker.example"
+	if rec := ts.serve(r, visitor); rec.Code != http.StatusOK {
+		t.Fatalf("forgot: status = %d", rec.Code)
+	}
+	email, _ := ts.mailer.last()
+	if !strings.Contains(email.Body, "https://protestpulse.test/auth/local/reset?token=") || strings.Contains(email.Body, "attacker.example") {
+		t.Errorf("reset email does not link to the app's domain: %q", email.Body)
+	}
+}
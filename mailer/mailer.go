@@ -0,0 +1,84 @@
+// Package mailer sends the emails of the app, such as password resets.
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Config contains SMTP connection parameters. The password may refer to an
+// environment variable as $VAR or ${VAR}.
+type Config struct {
+	Host     string `yaml:"smtp_host"`
+	Port     int    `yaml:"smtp_port"`
+	User     string `yaml:"smtp_user"`
+	Password string `yaml:"smtp_password"`
+	// From is the sender address of all emails.
+	From string `yaml:"from"`
+}
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// New returns a Mailer that sends through the SMTP server of c, or one that
+// only logs emails if c has no host, for development.
+func New(c Config) Mailer {
+	if c.Host == "" {
+		return logMailer{}
+	}
+	if c.Port == 0 {
+		c.Port = 587
+	}
+	c.Password = os.ExpandEnv(c.Password)
+	return &smtpMailer{config: c}
+}
+
+// smtpMailer sends emails through an SMTP server.
+type smtpMailer struct {
+	config Config
+}
+
+// Send sends a plain text email to a single recipient.
+func (m *smtpMailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("Invalid recipient %q", to)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	var auth smtp.Auth
+	if m.config.User != "" {
+		auth = smtp.PlainAuth("", m.config.User, m.config.Password, m.config.Host)
+	}
+	addr := m.config.Host + ":" + strconv.Itoa(m.config.Port)
+	return smtp.SendMail(addr, auth, m.config.From, []string{to}, msg.Bytes())
+}
+
+// logMailer logs emails instead of sending them.
+type logMailer struct{}
+
+// Send logs the email.
+func (logMailer) Send(to, subject, body string) error {
+	logrus.WithFields(logrus.Fields{
+		"to":      to,
+		"subject": subject,
+	}).Info("Not sending email without smtp_host:\n" + body)
+	return nil
+}
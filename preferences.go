@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+)
+
+// PreferencesGET handles GET requests for '/preferences' by showing the
+// event topics and types the user can follow, and the time zone they see
+// times in.
+func (a *App) PreferencesGET(w http.ResponseWriter, r *http.Request) {
+	p := requestProfile(r)
+
+	topics, types, err := a.lookups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	userTopics, err := a.store.UserTopics(p.UserID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get followed topics")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	userTypes, err := a.store.UserTypes(p.UserID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get followed types")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var zone string
+	if u, err := a.user(r, p); err == nil {
+		zone = u.TimeZone
+	}
+
+	data := map[string]interface{}{
+		"Page":           "Preferences",
+		"Topics":         topics,
+		"Types":          types,
+		"FollowedTopics": idSet(userTopics),
+		"FollowedTypes":  idSet(userTypes),
+		"TimeZone":       zone,
+		"DefaultZone":    a.location.String(),
+		"TimeZones":      timeZones,
+	}
+	a.renderTemplate(w, r, "preferences.tmpl", data)
+}
+
+// PreferencesPOST handles POST requests for '/preferences' by replacing the
+// event topics and types the user follows with the submitted ones. The time
+// zone is changed if submitted, where an empty zone selects the app's
+// default.
+func (a *App) PreferencesPOST(w http.ResponseWriter, r *http.Request) {
+	p := requestProfile(r)
+
+	topics, types, err := a.lookups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	r.ParseForm()
+	topicIDs, err := parseLookupIDs(r.Form["topic"], "topic", topics)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	typeIDs, err := parseLookupIDs(r.Form["type"], "type", types)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	zone := formString(r, "time_zone")
+	if zone != nil && *zone != "" {
+		if _, err = loadZone(*zone); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err = a.store.SetPreferences(p.UserID, topicIDs, typeIDs); err != nil {
+		logrus.WithError(err).Error("Failed to save preferences")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if zone != nil {
+		if err = a.store.SetUserTimeZone(p.UserID, *zone); err != nil {
+			logrus.WithError(err).Error("Failed to save time zone")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// parseLookupIDs parses each of vs with parseLookupID, skipping empty values.
+func parseLookupIDs(vs []string, name string, lookups []db.Lookup) ([]int, error) {
+	ids := []int{}
+	for _, v := range vs {
+		id, err := parseLookupID(v, name, lookups)
+		if err != nil {
+			return nil, err
+		}
+		if id != 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// idSet converts a list of ids into a set for membership checks in templates.
+func idSet(ids []int) map[int]bool {
+	set := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
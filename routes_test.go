@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/gorilla/mux"
+)
+
+// routeTest is a request to one of the routes registered in main and the
+// response expected for it. Paths may contain the placeholders {event}, an
+// event created by 'owner', {hidden}, a hidden event created by 'owner',
+// {fresh}, an event created by 'owner' for this request only, {missing},
+// an event that does not exist, and {feed}, the calendar feed key of
+// 'member'.
+type routeTest struct {
+	name string
+	// user is one of testUsers, or empty for an anonymous visitor.
+	user   string
+	method string
+	path   string
+	form   url.Values
+	// json, if set, is sent as a JSON body. Requests to the API always
+	// accept JSON.
+	json   interface{}
+	status int
+	// location, if set, is the expected start of the Location header.
+	location string
+	// contains, if set, must be in the response body.
+	contains string
+}
+
+// testUsers are the users routeTests are made by, and their roles.
+var testUsers = map[string]db.Role{
+	"member":    db.RoleMember,
+	"organizer": db.RoleOrganizer,
+	"owner":     db.RoleOrganizer,
+	"moderator": db.RoleModerator,
+	"admin":     db.RoleAdmin,
+}
+
+// routeTests returns the route tests of ts. Some responses depend on the
+// session store of the server.
+func routeTests(ts *testServer) []routeTest {
+	revokeStatus := http.StatusNotImplemented
+	if _, ok := ts.app.sessionStore.(interface {
+		RevokeUserSessions(string) (int, error)
+	}); ok {
+		revokeStatus = http.StatusSeeOther
+	}
+
+	newEvent := url.Values{
+		"title":       {"Form march"},
+		"description": {"Created with the event form"},
+		"location":    {"Main Square"},
+		"event_type":  {"1"},
+		"event_topic": {"2"},
+		"start_date":  {testDate(0)},
+		"start_time":  {"10:00"},
+		"end_date":    {testDate(0)},
+		"end_time":    {"12:00"},
+	}
+	newAPIEvent := map[string]interface{}{
+		"title":    "API march",
+		"start":    testDate(31) + "T10:00:00Z",
+		"end":      testDate(31) + "T12:00:00Z",
+		"type":     2,
+		"topic":    3,
+		"location": "Online",
+	}
+	importCSV := "title,start,end,topic,type,location\n" +
+		"Imported rally," + testDate(61) + " 10:00," + testDate(61) + " 12:00,environment,in person,Park\n"
+	importForm := func(action string) url.Values {
+		return url.Values{
+			"filename": {"events.csv"},
+			"content":  {base64.StdEncoding.EncodeToString([]byte(importCSV))},
+			"action":   {action},
+		}
+	}
+
+	return []routeTest{
+		// Authentication.
+		{name: "login page", method: "GET", path: "/auth/login", status: http.StatusOK, contains: `href="/auth/login/mock"`},
+		{name: "login with provider", method: "GET", path: "/auth/login/mock", status: http.StatusFound, location: ts.idp.URL + "/authorize?"},
+		{name: "login with unknown provider", method: "GET", path: "/auth/login/other", status: http.StatusNotFound},
+		{name: "callback without state", method: "GET", path: "/auth/callback?code=c&state=s", status: http.StatusForbidden},
+		{name: "logout", user: "member", method: "GET", path: "/auth/logout", status: http.StatusSeeOther, location: "/auth/login"},
+		{name: "local login with wrong password", method: "POST", path: "/auth/local/login",
+			form:   url.Values{"email": {"nobody@example.com"}, "password": {"wrong password"}},
+			status: http.StatusUnauthorized, contains: errInvalidLogin},
+		{name: "signup page", method: "GET", path: "/auth/local/signup", status: http.StatusOK},
+		{name: "signup with short password", method: "POST", path: "/auth/local/signup",
+			form:   url.Values{"email": {"new@example.com"}, "password": {"short"}, "confirm_password": {"short"}},
+			status: http.StatusBadRequest},
+		{name: "forgot password page", method: "GET", path: "/auth/local/forgot", status: http.StatusOK},
+		{name: "forgot password of unknown email", method: "POST", path: "/auth/local/forgot",
+			form: url.Values{"email": {"nobody@example.com"}}, status: http.StatusOK},
+		{name: "reset page with unknown token", method: "GET", path: "/auth/local/reset?token=unknown", status: http.StatusNotFound},
+		{name: "reset with unknown token", method: "POST", path: "/auth/local/reset",
+			form:   url.Values{"token": {"unknown"}, "password": {"a new password"}, "confirm_password": {"a new password"}},
+			status: http.StatusNotFound},
+
+		// Pages of logged in users.
+		{name: "home when anonymous", method: "GET", path: "/", status: http.StatusSeeOther, location: "/auth/login"},
+		{name: "home", user: "member", method: "GET", path: "/", status: http.StatusOK},
+		{name: "preferences", user: "member", method: "GET", path: "/preferences", status: http.StatusOK},
+		{name: "save preferences", user: "member", method: "POST", path: "/preferences",
+			form: url.Values{"topic": {"1", "2"}, "type": {"1"}}, status: http.StatusSeeOther, location: "/"},
+		{name: "save repeated preferences", user: "member", method: "POST", path: "/preferences",
+			form: url.Values{"topic": {"1", "1"}, "type": {"2", "2"}}, status: http.StatusSeeOther, location: "/"},
+		{name: "save unknown preferences", user: "member", method: "POST", path: "/preferences",
+			form: url.Values{"topic": {"99"}}, status: http.StatusBadRequest},
+		{name: "save unknown time zone", user: "member", method: "POST", path: "/preferences",
+			form: url.Values{"time_zone": {"Mars/Olympus_Mons"}}, status: http.StatusBadRequest},
+		{name: "save preferences when anonymous", method: "POST", path: "/preferences",
+			form: url.Values{"topic": {"1"}}, status: http.StatusSeeOther, location: "/auth/login"},
+		{name: "tokens", user: "member", method: "GET", path: "/settings/tokens", status: http.StatusOK},
+		{name: "create token", user: "member", method: "POST", path: "/settings/tokens",
+			form: url.Values{"name": {"cli"}}, status: http.StatusOK, contains: apiTokenPrefix},
+		{name: "create token without name", user: "member", method: "POST", path: "/settings/tokens",
+			form: url.Values{}, status: http.StatusBadRequest},
+		{name: "revoke token", user: "member", method: "POST", path: "/settings/tokens/1/revoke",
+			status: http.StatusSeeOther, location: "/settings/tokens"},
+		{name: "sessions", user: "member", method: "GET", path: "/settings/sessions", status: http.StatusOK},
+		{name: "revoke sessions", user: "member", method: "POST", path: "/settings/sessions/revoke", status: revokeStatus},
+		{name: "calendar settings", user: "member", method: "GET", path: "/settings/calendar", status: http.StatusOK, contains: "/calendar/{feed}/attending.ics"},
+		{name: "reset calendar key", user: "organizer", method: "POST", path: "/settings/calendar/reset",
+			status: http.StatusSeeOther, location: "/settings/calendar"},
+
+		// Public pages and feeds.
+		{name: "calendar feed", method: "GET", path: "/calendar/{feed}/attending.ics", status: http.StatusOK, contains: "BEGIN:VCALENDAR"},
+		{name: "calendar feed with unknown key", method: "GET", path: "/calendar/unknown/attending.ics", status: http.StatusNotFound},
+		{name: "unknown calendar feed", method: "GET", path: "/calendar/{feed}/other.ics", status: http.StatusNotFound},
+		{name: "events feed", method: "GET", path: "/feeds/events.atom", status: http.StatusOK, contains: "Shared event"},
+		{name: "topic feed", method: "GET", path: "/feeds/topics/1.rss", status: http.StatusOK, contains: "Shared event"},
+		{name: "unknown type feed", method: "GET", path: "/feeds/types/99.atom", status: http.StatusNotFound},
+		{name: "search", method: "GET", path: "/search?q=shared", status: http.StatusOK, contains: "<mark>"},
+		{name: "search without query", method: "GET", path: "/search", status: http.StatusOK},
+		{name: "user page", method: "GET", path: "/users/owner", status: http.StatusOK, contains: "Shared event"},
+		{name: "unknown user page", method: "GET", path: "/users/nobody", status: http.StatusNotFound},
+
+		// Administration.
+		{name: "admin users as member", user: "member", method: "GET", path: "/admin/users", status: http.StatusForbidden},
+		{name: "admin users", user: "admin", method: "GET", path: "/admin/users", status: http.StatusOK, contains: "User member"},
+		{name: "assign role", user: "admin", method: "POST", path: "/admin/users/member/role",
+			form: url.Values{"role": {"organizer"}}, status: http.StatusSeeOther, location: "/admin/users"},
+		{name: "assign unknown role", user: "admin", method: "POST", path: "/admin/users/member/role",
+			form: url.Values{"role": {"owner"}}, status: http.StatusBadRequest},
+		{name: "assign role to unknown user", user: "admin", method: "POST", path: "/admin/users/nobody/role",
+			form: url.Values{"role": {"organizer"}}, status: http.StatusNotFound},
+		{name: "assign role as moderator", user: "moderator", method: "POST", path: "/admin/users/member/role",
+			form: url.Values{"role": {"admin"}}, status: http.StatusForbidden},
+		{name: "revoke sessions of user", user: "admin", method: "POST", path: "/admin/users/member/sessions/revoke", status: revokeStatus},
+
+		// Events.
+		{name: "events", method: "GET", path: "/events", status: http.StatusOK, contains: "Shared event"},
+		{name: "events with invalid filter", method: "GET", path: "/events?topic=abc", status: http.StatusBadRequest},
+		{name: "create event when anonymous", method: "POST", path: "/events", form: newEvent,
+			status: http.StatusSeeOther, location: "/auth/login"},
+		{name: "create event as member", user: "member", method: "POST", path: "/events", form: newEvent,
+			status: http.StatusSeeOther, location: "/events/"},
+		{name: "create event", user: "organizer", method: "POST", path: "/events", form: newEvent,
+			status: http.StatusSeeOther, location: "/events/"},
+		{name: "create event with invalid date", user: "organizer", method: "POST", path: "/events",
+			form: url.Values{"title": {"Bad date"}, "start_date": {"May 1"}, "start_time": {"10:00"}}, status: http.StatusUnprocessableEntity,
+			contains: "Invalid start date or time"},
+		{name: "import page", user: "organizer", method: "GET", path: "/events/import", status: http.StatusOK},
+		{name: "import page as member", user: "member", method: "GET", path: "/events/import", status: http.StatusOK},
+		{name: "preview import", user: "organizer", method: "POST", path: "/events/import", form: importForm("preview"),
+			status: http.StatusOK, contains: "Imported rally"},
+		{name: "import", user: "organizer", method: "POST", path: "/events/import", form: importForm("import"),
+			status: http.StatusSeeOther, location: "/events"},
+		{name: "import without file", user: "organizer", method: "POST", path: "/events/import", form: url.Values{},
+			status: http.StatusBadRequest},
+		{name: "event", method: "GET", path: "/events/{event}", status: http.StatusOK, contains: "Shared event"},
+		{name: "unknown event", method: "GET", path: "/events/{missing}", status: http.StatusNotFound},
+		{name: "hidden event", method: "GET", path: "/events/{hidden}", status: http.StatusNotFound},
+		{name: "hidden event as owner", user: "owner", method: "GET", path: "/events/{hidden}", status: http.StatusOK, contains: "Hidden event"},
+		{name: "hidden event as moderator", user: "moderator", method: "GET", path: "/events/{hidden}", status: http.StatusOK},
+		{name: "event calendar", method: "GET", path: "/events/{event}.ics", status: http.StatusOK, contains: "BEGIN:VEVENT"},
+		{name: "unknown event calendar", method: "GET", path: "/events/{missing}.ics", status: http.StatusNotFound},
+		{name: "edit event", user: "owner", method: "POST", path: "/events/{fresh}", form: url.Values{"title": {"Edited"}},
+			status: http.StatusSeeOther, location: "/events/"},
+		{name: "edit event with end before start", user: "owner", method: "POST", path: "/events/{fresh}",
+			form: url.Values{"end_date": {"2000-01-01"}, "end_time": {"10:00"}}, status: http.StatusUnprocessableEntity,
+			contains: "End time must be after the start time"},
+		{name: "edit event of others", user: "organizer", method: "POST", path: "/events/{fresh}", form: url.Values{"title": {"Edited"}},
+			status: http.StatusForbidden},
+		{name: "edit event as moderator", user: "moderator", method: "PUT", path: "/events/{fresh}", form: url.Values{"title": {"Edited"}},
+			status: http.StatusNoContent},
+		{name: "patch event", user: "owner", method: "PATCH", path: "/events/{fresh}", form: url.Values{"location": {"Elsewhere"}},
+			status: http.StatusNoContent},
+		{name: "edit unknown event", user: "moderator", method: "POST", path: "/events/{missing}", form: url.Values{"title": {"Edited"}},
+			status: http.StatusNotFound},
+		{name: "delete event", user: "owner", method: "POST", path: "/events/{fresh}/delete", status: http.StatusSeeOther, location: "/events"},
+		{name: "delete event of others", user: "moderator", method: "POST", path: "/events/{fresh}/delete", status: http.StatusForbidden},
+		{name: "delete event as admin", user: "admin", method: "DELETE", path: "/events/{fresh}", status: http.StatusNoContent},
+		{name: "delete event when anonymous", method: "DELETE", path: "/events/{fresh}", status: http.StatusSeeOther, location: "/auth/login"},
+		{name: "hide event", user: "moderator", method: "POST", path: "/events/{fresh}/hide", status: http.StatusSeeOther, location: "/events/"},
+		{name: "hide event as owner", user: "owner", method: "POST", path: "/events/{fresh}/hide", status: http.StatusForbidden},
+		{name: "hide unknown event", user: "moderator", method: "POST", path: "/events/{missing}/hide", status: http.StatusNotFound},
+		{name: "unhide event", user: "moderator", method: "POST", path: "/events/{fresh}/unhide", status: http.StatusSeeOther, location: "/events/"},
+		{name: "attend event", user: "member", method: "POST", path: "/events/{event}/attend", status: http.StatusSeeOther, location: "/events/"},
+		{name: "attend unknown event", user: "member", method: "POST", path: "/events/{missing}/attend", status: http.StatusNotFound},
+		{name: "attend hidden event", user: "member", method: "POST", path: "/events/{hidden}/attend", status: http.StatusNotFound},
+		{name: "attend hidden event as owner", user: "owner", method: "POST", path: "/events/{hidden}/attend", status: http.StatusSeeOther, location: "/events/"},
+		{name: "unattend event", user: "member", method: "POST", path: "/events/{event}/unattend", status: http.StatusSeeOther, location: "/events/"},
+
+		// JSON API.
+		{name: "API events", method: "GET", path: "/api/v1/events", status: http.StatusOK, contains: "Shared event"},
+		{name: "API events with invalid filter", method: "GET", path: "/api/v1/events?type=abc", status: http.StatusBadRequest, contains: `"error"`},
+		{name: "API create event", user: "organizer", method: "POST", path: "/api/v1/events", json: newAPIEvent,
+			status: http.StatusCreated, location: "/api/v1/events/", contains: "API march"},
+		{name: "API create event without fields", user: "organizer", method: "POST", path: "/api/v1/events",
+			json: map[string]interface{}{"title": "Incomplete"}, status: http.StatusUnprocessableEntity, contains: `"location":"Location is required"`},
+		{name: "API create event as member", user: "member", method: "POST", path: "/api/v1/events", json: newAPIEvent,
+			status: http.StatusCreated, location: "/api/v1/events/"},
+		{name: "API create event when anonymous", method: "POST", path: "/api/v1/events", json: newAPIEvent,
+			status: http.StatusUnauthorized, contains: `"error"`},
+		{name: "API event", method: "GET", path: "/api/v1/events/{event}", status: http.StatusOK, contains: "Shared event"},
+		{name: "API unknown event", method: "GET", path: "/api/v1/events/{missing}", status: http.StatusNotFound, contains: `"error"`},
+		{name: "API hidden event", method: "GET", path: "/api/v1/events/{hidden}", status: http.StatusNotFound},
+		{name: "API edit event", user: "owner", method: "PUT", path: "/api/v1/events/{fresh}",
+			json: map[string]interface{}{"title": "Renamed"}, status: http.StatusOK, contains: "Renamed"},
+		{name: "API patch event as moderator", user: "moderator", method: "PATCH", path: "/api/v1/events/{fresh}",
+			json: map[string]interface{}{"location": "Elsewhere"}, status: http.StatusOK, contains: "Elsewhere"},
+		{name: "API edit event of others", user: "organizer", method: "PUT", path: "/api/v1/events/{fresh}",
+			json: map[string]interface{}{"title": "Renamed"}, status: http.StatusForbidden},
+		{name: "API delete event", user: "owner", method: "DELETE", path: "/api/v1/events/{fresh}", status: http.StatusNoContent},
+		{name: "API delete event of others", user: "moderator", method: "DELETE", path: "/api/v1/events/{fresh}", status: http.StatusForbidden},
+		{name: "API attend event", user: "member", method: "PUT", path: "/api/v1/events/{event}/attendance", status: http.StatusNoContent},
+		{name: "API attend unknown event", user: "member", method: "PUT", path: "/api/v1/events/{missing}/attendance", status: http.StatusNotFound},
+		{name: "API attend hidden event", user: "member", method: "PUT", path: "/api/v1/events/{hidden}/attendance", status: http.StatusNotFound, contains: `"error"`},
+		{name: "API unattend event", user: "member", method: "DELETE", path: "/api/v1/events/{event}/attendance", status: http.StatusNoContent},
+		{name: "API my events", user: "member", method: "GET", path: "/api/v1/me/events", status: http.StatusOK, contains: `"events"`},
+		{name: "API my events when anonymous", method: "GET", path: "/api/v1/me/events", status: http.StatusUnauthorized},
+		{name: "API topics", method: "GET", path: "/api/v1/topics", status: http.StatusOK, contains: "environment"},
+		{name: "API types", method: "GET", path: "/api/v1/types", status: http.StatusOK, contains: "online"},
+	}
+}
+
+// TestRoutes makes the requests of routeTests and checks that every route
+// registered in main is requested by at least one of them.
+func TestRoutes(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	for id, role := range testUsers {
+		ts.login(id, role)
+	}
+	shared := ts.createEvent("owner", "Shared event")
+	hidden := ts.createEvent("owner", "Hidden event")
+	if err := ts.app.store.HideEvent(hidden.ID, "moderator"); err != nil {
+		t.Fatal(err)
+	}
+	feedKey, err := ts.app.store.CalendarFeedKey("member", "member-feed-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := ts.app.router()
+	var requests []*http.Request
+	for _, tt := range routeTests(ts) {
+		path := strings.NewReplacer(
+			"{event}", strconv.Itoa(shared.ID),
+			"{hidden}", strconv.Itoa(hidden.ID),
+			"{missing}", "999999",
+			"{feed}", feedKey,
+		).Replace(tt.path)
+		if strings.Contains(path, "{fresh}") {
+			fresh := ts.createEvent("owner", "Fresh event")
+			path = strings.Replace(path, "{fresh}", strconv.Itoa(fresh.ID), -1)
+		}
+		contains := strings.Replace(tt.contains, "{feed}", feedKey, -1)
+
+		var r *http.Request
+		switch {
+		case tt.json != nil || strings.HasPrefix(path, "/api/"):
+			r = jsonRequest(t, tt.method, path, tt.json)
+		case tt.form != nil:
+			r = formRequest(tt.method, path, tt.form)
+		default:
+			r = httptest.NewRequest(tt.method, path, nil)
+		}
+		requests = append(requests, r)
+
+		u := ts.anonymous()
+		if tt.user != "" {
+			u = ts.login(tt.user, testUsers[tt.user])
+		}
+		rec := ts.serve(r, u)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: %s %s: status = %d, want %d, body %q", tt.name, tt.method, path, rec.Code, tt.status, rec.Body.String())
+			continue
+		}
+		if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, tt.location) {
+			t.Errorf("%s: redirected to %q, want %q", tt.name, loc, tt.location)
+		}
+		if !strings.Contains(rec.Body.String(), contains) {
+			t.Errorf("%s: body does not contain %q: %q", tt.name, contains, rec.Body.String())
+		}
+	}
+
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		var m mux.RouteMatch
+		for _, r := range requests {
+			if route.Match(r, &m) {
+				return nil
+			}
+		}
+		t.Errorf("No route test requests %s", routeTemplate(route))
+		return nil
+	})
+}
+
+// routeTemplate returns the path template of a route, for naming it in test
+// failures. The vendored version of mux does not export it.
+func routeTemplate(route *mux.Route) string {
+	v := reflect.ValueOf(route).Elem().FieldByName("regexp")
+	if v.IsNil() {
+		return "a route without a path"
+	}
+	return v.Elem().FieldByName("path").Elem().FieldByName("template").String()
+}
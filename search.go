@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// searchResultsCount is the maximum number of results shown by '/search'.
+const searchResultsCount = 50
+
+// SearchGET handles GET requests for '/search' by running a full-text search
+// for the 'q' query parameter over event titles, descriptions and locations.
+// Clients that accept JSON receive the results as JSON instead of HTML.
+func (a *App) SearchGET(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	data := map[string]interface{}{
+		"Page":  "Search",
+		"Query": q,
+	}
+	if q == "" {
+		if wantsJSON(r) {
+			writeJSONError(w, http.StatusBadRequest, "Missing search query q")
+			return
+		}
+		a.renderTemplate(w, r, "search.tmpl", data)
+		return
+	}
+
+	results, err := a.store.SearchEvents(q, searchResultsCount)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to search events")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Give start times in the time zone of each event.
+	for i := range results {
+		results[i].Start = results[i].Start.In(a.eventZone(results[i].TimeZone))
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"query":   q,
+			"results": results,
+		})
+		return
+	}
+
+	data["Results"] = results
+	a.renderTemplate(w, r, "search.tmpl", data)
+}
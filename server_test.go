@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/identity"
+	"github.com/chloearianne/protestpulse/identity/identitytest"
+	"github.com/chloearianne/protestpulse/session"
+	"github.com/gorilla/sessions"
+)
+
+// testDBEnv names the environment variable holding the connection string of
+// a Postgres database to run the handler tests against, such as
+// 'user=protestpulse dbname=protestpulse_test host=localhost sslmode=disable'.
+// Each test server migrates a throwaway schema of its own, which is dropped
+// when the server is closed. Without it, the tests use a db.MemoryStore.
+const testDBEnv = "PROTESTPULSE_TEST_DB"
+
+// testCSRFToken is the CSRF token in the auth-session of test users.
+const testCSRFToken = "test-csrf-token"
+
+// newTestApp returns an App with a cookie store, the templates and an
+// in-memory store, but no database.
+func newTestApp() *App {
+	gob.Register(&session.Profile{})
+	return &App{
+		store:        db.NewMemoryStore(),
+		sessionStore: sessions.NewCookieStore([]byte("test-cookie-key")),
+		templateMap:  getTemplateMap(),
+		location:     time.UTC,
+		domain:       "protestpulse.test",
+	}
+}
+
+// testServer serves an App through the same middleware and routes as main.
+type testServer struct {
+	t       *testing.T
+	app     *App
+	handler http.Handler
+	// idp is the identity provider users can log in with, as "mock".
+	idp    *identitytest.Server
+	mailer *testMailer
+	// dropSchema drops the Postgres schema of the server, if it has one.
+	dropSchema func()
+}
+
+// newTestServer returns a testServer with local accounts and the mock
+// identity provider enabled. It uses a Postgres schema if testDBEnv is set,
+// and a MemoryStore otherwise. The caller should call Close when finished.
+func newTestServer(t *testing.T) *testServer {
+	a := newTestApp()
+	ts := &testServer{t: t, app: a, idp: identitytest.NewServer(), mailer: &testMailer{}}
+
+	providers, err := identity.NewProviders([]identity.Config{ts.idp.Config("mock", testCallbackURL)})
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	a.providers = providers
+	a.localAccounts = true
+	a.mailer = ts.mailer
+
+	if dsn := os.Getenv(testDBEnv); dsn != "" {
+		database, drop, err := newTestSchema(dsn)
+		if err != nil {
+			ts.Close()
+			t.Fatalf("Failed to create test schema: %v", err)
+		}
+		ts.dropSchema = drop
+		a.db = database
+		a.store = database
+		a.sessionStore = session.NewPGStore(database, []byte("test-cookie-key"))
+	}
+
+	ts.handler = a.middleware(a.router())
+	return ts
+}
+
+// Close shuts down the identity provider and drops the Postgres schema.
+func (ts *testServer) Close() {
+	ts.idp.Close()
+	if ts.dropSchema != nil {
+		ts.dropSchema()
+	}
+}
+
+// newTestSchema creates a schema in the database of dsn and migrates it, and
+// returns a Database using it along with a function dropping it.
+func newTestSchema(dsn string) (*db.Database, func(), error) {
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	schema := fmt.Sprintf("test_%d_%d", os.Getpid(), time.Now().UnixNano())
+	if _, err = admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		admin.Close()
+		return nil, nil, err
+	}
+
+	conn, err := sql.Open("postgres", withSearchPath(dsn, schema))
+	database := &db.Database{DB: conn}
+	drop := func() {
+		if conn != nil {
+			conn.Close()
+		}
+		admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		admin.Close()
+	}
+	if err != nil {
+		drop()
+		return nil, nil, err
+	}
+
+	migrations, err := db.LoadMigrations(migrationsDir)
+	if err == nil {
+		_, err = database.MigrateUp(migrations, 0, migrationSettings(time.UTC))
+	}
+	if err != nil {
+		drop()
+		return nil, nil, err
+	}
+	return database, drop, nil
+}
+
+// withSearchPath adds the search_path run-time parameter to a lib/pq
+// connection string, which may be a URL or a list of key=value pairs.
+func withSearchPath(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
+
+// testUser is a visitor of a testServer, logged in unless ID is empty.
+type testUser struct {
+	ID      string
+	cookies []*http.Cookie
+}
+
+// login saves a user with the given id and role and returns them logged in,
+// with a CSRF token in their auth-session.
+func (ts *testServer) login(id string, role db.Role) *testUser {
+	u := &db.User{ID: id, DisplayName: "User " + id, Email: id + "@example.com"}
+	if err := ts.app.store.SaveLogin(u); err != nil {
+		ts.t.Fatal(err)
+	}
+	if err := ts.app.store.SetUserRole(id, role); err != nil {
+		ts.t.Fatal(err)
+	}
+	cookies := sessionCookies(ts.t, ts.app, map[string]interface{}{
+		"profile":    &session.Profile{UserID: id, Email: u.Email, GivenName: "User", FamilyName: id},
+		csrfTokenKey: testCSRFToken,
+	})
+	return &testUser{ID: id, cookies: cookies}
+}
+
+// anonymous returns a visitor who is not logged in, but has the CSRF token
+// of the login and signup forms in their csrf_token cookie.
+func (ts *testServer) anonymous() *testUser {
+	return &testUser{cookies: []*http.Cookie{{Name: csrfCookie, Value: testCSRFToken}}}
+}
+
+// testDay is the day of the events tests submit: May 1 of next year, so
+// that their start times are always in the future, and always on the same
+// side of daylight saving time changes.
+var testDay = time.Date(time.Now().Year()+1, time.May, 1, 0, 0, 0, 0, time.UTC)
+
+// testDate returns the date the given number of days after testDay, as
+// entered in forms.
+func testDate(days int) string {
+	return testDay.AddDate(0, 0, days).Format(formDateFormat)
+}
+
+// createEvent saves an event created by creatorID, starting in a week.
+func (ts *testServer) createEvent(creatorID, title string) *db.Event {
+	start := time.Now().UTC().AddDate(0, 0, 7).Truncate(time.Minute)
+	e := &db.Event{
+		CreatorID:   creatorID,
+		Title:       title,
+		Start:       start,
+		End:         start.Add(2 * time.Hour),
+		TimeZone:    "UTC",
+		Description: "Description of " + title,
+		Topic:       1,
+		Type:        1,
+		Location:    "City Hall",
+	}
+	if err := ts.app.store.CreateEvent(e); err != nil {
+		ts.t.Fatal(err)
+	}
+	return e
+}
+
+// serve serves r as u, or without any cookies if u is nil. Unsafe requests
+// of users carry their CSRF token.
+func (ts *testServer) serve(r *http.Request, u *testUser) *httptest.ResponseRecorder {
+	if u != nil {
+		for _, c := range u.cookies {
+			r.AddCookie(c)
+		}
+		switch r.Method {
+		case "GET", "HEAD":
+		default:
+			r.Header.Set(csrfHeader, testCSRFToken)
+		}
+	}
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, r)
+	return rec
+}
+
+// get serves a GET request for path.
+func (ts *testServer) get(path string, u *testUser) *httptest.ResponseRecorder {
+	return ts.serve(httptest.NewRequest("GET", path, nil), u)
+}
+
+// submit serves a request with form as its url-encoded body.
+func (ts *testServer) submit(method, path string, form url.Values, u *testUser) *httptest.ResponseRecorder {
+	return ts.serve(formRequest(method, path, form), u)
+}
+
+// sendJSON serves a request accepting JSON, with v encoded as its body if
+// v is not nil.
+func (ts *testServer) sendJSON(method, path string, v interface{}, u *testUser) *httptest.ResponseRecorder {
+	return ts.serve(jsonRequest(ts.t, method, path, v), u)
+}
+
+// decodeJSON decodes the JSON body of rec into v.
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("Invalid JSON body %q: %v", rec.Body.String(), err)
+	}
+}
+
+// testMailer is a mailer.Mailer keeping the emails it is asked to send.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []testEmail
+}
+
+type testEmail struct {
+	To, Subject, Body string
+}
+
+// Send keeps the email.
+func (m *testMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, testEmail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// last returns the last email sent, or false if there is none.
+func (m *testMailer) last() (testEmail, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sent) == 0 {
+		return testEmail{}, false
+	}
+	return m.sent[len(m.sent)-1], true
+}
+
+// sessionCookies returns the cookies of an auth-session holding values.
+func sessionCookies(t *testing.T, a *App, values map[string]interface{}) []*http.Cookie {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	session, err := a.sessionStore.Get(r, "auth-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		session.Values[k] = v
+	}
+	if err = session.Save(r, rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Result().Cookies()
+}
+
+// sessionValue returns the value stored under key in the auth-session set
+// by a response.
+func sessionValue(t *testing.T, a *App, rec *httptest.ResponseRecorder, key string) interface{} {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	session, err := a.sessionStore.Get(r, "auth-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.Values[key]
+}
+
+// jsonRequest returns a request accepting JSON, with v encoded as its body
+// if v is not nil.
+func jsonRequest(t *testing.T, method, path string, v interface{}) *http.Request {
+	r := httptest.NewRequest(method, path, nil)
+	if v != nil {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = httptest.NewRequest(method, path, bytes.NewReader(b))
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
+	return r
+}
+
+func formRequest(method, path string, form url.Values) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
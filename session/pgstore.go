@@ -0,0 +1,187 @@
+package session
+
+import (
+	"database/sql"
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Revoker is implemented by session stores that keep sessions on the server,
+// where they can be listed and ended.
+type Revoker interface {
+	// UserSessions returns the active sessions of the user.
+	UserSessions(userID string) ([]db.Session, error)
+	// RevokeUserSessions ends all sessions of the user and returns how many
+	// there were.
+	RevokeUserSessions(userID string) (int, error)
+	// RevokeSession ends the session with the given id.
+	RevokeSession(id string) error
+}
+
+// PGStore is a sessions.Store keeping session values in Postgres. The
+// session cookie only holds the signed session id, so sessions can be
+// revoked on the server.
+type PGStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	db      *db.Database
+}
+
+// NewPGStore returns a PGStore saving sessions in database. The key pairs
+// are used as in sessions.NewCookieStore.
+func NewPGStore(database *db.Database, keyPairs ...[]byte) *PGStore {
+	s := &PGStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		db: database,
+	}
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *PGStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the
+// registry. A cookie naming a session that has expired or was revoked
+// yields a new, empty session rather than an error.
+func (s *PGStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err = securecookie.DecodeMulti(name, c.Value, &id, s.Codecs...); err != nil {
+		return session, nil
+	}
+
+	stored, err := s.db.GetSession(id)
+	if err == sql.ErrNoRows {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if err = securecookie.DecodeMulti(name, stored.Data, &session.Values, s.Codecs...); err != nil {
+		return session, err
+	}
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save saves the session values and sets the session cookie. A session with
+// a negative MaxAge is deleted.
+func (s *PGStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.db.DeleteSession(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	stored := &db.Session{
+		ID:        session.ID,
+		Data:      data,
+		ExpiresAt: time.Now().Add(time.Duration(s.maxAge(session)) * time.Second),
+	}
+	if p, ok := session.Values["profile"].(*Profile); ok && p != nil {
+		stored.UserID = p.UserID
+	}
+	if err = s.db.SaveSession(stored); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// MaxAge sets the maximum age of new sessions, in seconds.
+func (s *PGStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// maxAge returns how long the session is kept. Sessions without a MaxAge
+// last until the browser is closed, which the server cannot tell, so they
+// are kept as long as the store's default.
+func (s *PGStore) maxAge(session *sessions.Session) int {
+	if session.Options.MaxAge > 0 {
+		return session.Options.MaxAge
+	}
+	return s.Options.MaxAge
+}
+
+// UserSessions returns the active sessions of the user.
+func (s *PGStore) UserSessions(userID string) ([]db.Session, error) {
+	return s.db.UserSessions(userID)
+}
+
+// RevokeUserSessions deletes all sessions of the user.
+func (s *PGStore) RevokeUserSessions(userID string) (int, error) {
+	return s.db.DeleteUserSessions(userID)
+}
+
+// RevokeSession deletes the session with the given id.
+func (s *PGStore) RevokeSession(id string) error {
+	return s.db.DeleteSession(id)
+}
+
+// StartCleanup deletes expired sessions every interval until the returned
+// stop function is called.
+func (s *PGStore) StartCleanup(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := s.db.DeleteExpiredSessions()
+				if err != nil {
+					logrus.WithError(err).Error("Failed to delete expired sessions")
+				} else if n > 0 {
+					logrus.WithField("count", n).Info("Deleted expired sessions")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
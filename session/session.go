@@ -1,12 +1,18 @@
 package session
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
 	"github.com/gorilla/sessions"
 )
 
+type contextKey int
+
+// profileKey is the context key of a Profile set with NewContext.
+const profileKey contextKey = 0
+
 // Profile contains user data provided by the auth service.
 type Profile struct {
 	UserID     string `json:"user_id"`
@@ -16,10 +22,28 @@ type Profile struct {
 	Picture    string `json:"picture"`
 }
 
-// GetProfile introspects the auth-session of the given cookie and request
-// and returns a Profile of user data.
-func GetProfile(r *http.Request, cookie *sessions.CookieStore) (*Profile, error) {
-	session, err := cookie.Get(r, "auth-session")
+// NewContext returns a copy of ctx carrying the Profile of the user making
+// a request, whether they are authenticated by the auth-session or by other
+// means such as API tokens. It takes precedence over the auth-session in
+// GetProfile.
+func NewContext(ctx context.Context, p *Profile) context.Context {
+	return context.WithValue(ctx, profileKey, p)
+}
+
+// FromContext returns the Profile carried by ctx, if any.
+func FromContext(ctx context.Context) (*Profile, bool) {
+	p, ok := ctx.Value(profileKey).(*Profile)
+	return p, ok && p != nil
+}
+
+// GetProfile returns the Profile attached to the request context, or else
+// introspects the auth-session of the given store and request.
+func GetProfile(r *http.Request, store sessions.Store) (*Profile, error) {
+	if p, ok := FromContext(r.Context()); ok {
+		return p, nil
+	}
+
+	session, err := store.Get(r, "auth-session")
 	if err != nil {
 		return nil, fmt.Errorf("Could not get auth-session: %v", err)
 	}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/session"
+	"github.com/gorilla/mux"
+)
+
+// SessionsGET handles GET requests for '/settings/sessions', listing the
+// devices the user is logged in on.
+func (a *App) SessionsGET(w http.ResponseWriter, r *http.Request) {
+	p, err := a.browserProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Page": "Settings",
+	}
+	if revoker, ok := a.sessionStore.(session.Revoker); ok {
+		sessions, err := revoker.UserSessions(p.UserID)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to get sessions")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		current, err := a.sessionStore.Get(r, "auth-session")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data["Sessions"] = sessions
+		data["CurrentID"] = current.ID
+		data["Revocable"] = true
+	}
+	a.renderTemplate(w, r, "sessions.tmpl", data)
+}
+
+// SessionsRevokePOST handles POST requests for '/settings/sessions/revoke'
+// by logging the user out on all devices, including this one.
+func (a *App) SessionsRevokePOST(w http.ResponseWriter, r *http.Request) {
+	p, err := a.browserProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	revoker, ok := a.sessionStore.(session.Revoker)
+	if !ok {
+		http.Error(w, "Sessions cannot be revoked with this session store", http.StatusNotImplemented)
+		return
+	}
+
+	n, err := revoker.RevokeUserSessions(p.UserID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to revoke sessions")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logrus.WithField("user", p.UserID).WithField("count", n).Info("Revoked sessions")
+
+	// Expire the cookie of this device, whose session is already gone.
+	current, err := a.sessionStore.Get(r, "auth-session")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	current.Options.MaxAge = -1
+	if err = current.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+}
+
+// AdminSessionsRevokePOST handles POST requests for
+// '/admin/users/{id}/sessions/revoke' by logging the user out on all
+// devices, for example after their account was compromised.
+func (a *App) AdminSessionsRevokePOST(w http.ResponseWriter, r *http.Request) {
+	revoker, ok := a.sessionStore.(session.Revoker)
+	if !ok {
+		http.Error(w, "Sessions cannot be revoked with this session store", http.StatusNotImplemented)
+		return
+	}
+
+	userID := mux.Vars(r)["id"]
+	n, err := revoker.RevokeUserSessions(userID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to revoke sessions")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"user":  userID,
+		"admin": requestProfile(r).UserID,
+		"count": n,
+	}).Info("Admin revoked sessions")
+	http.Redirect(w, r, userURL(userID), http.StatusSeeOther)
+}
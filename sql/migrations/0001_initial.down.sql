@@ -0,0 +1,6 @@
+DROP TABLE user_events;
+DROP TABLE user_event_types;
+DROP TABLE user_event_topics;
+DROP TABLE event;
+DROP TABLE event_topic;
+DROP TABLE event_type;
@@ -1,17 +1,7 @@
-DROP DATABASE IF EXISTS ppdb;
-CREATE DATABASE ppdb;
-
-DROP OWNED BY ppmaster CASCADE;
-REVOKE ALL ON ALL TABLES IN SCHEMA public FROM PUBLIC;
-
-DROP USER IF EXISTS ppmaster;
-CREATE USER ppmaster;
-
-ALTER DATABASE ppdb OWNER TO ppmaster;
-GRANT ALL PRIVILEGES ON DATABASE ppdb TO ppmaster;
-
--- Connect to newly created database
-\c ppdb ppmaster;
+-- The original schema of the app, which sql/schema.sql created before
+-- migrations were introduced. Databases created from that file already
+-- have it: mark this migration as applied with 'protestpulse migrate
+-- baseline' instead of running it.
 
 CREATE TABLE event_type (
     id    SERIAL PRIMARY KEY,
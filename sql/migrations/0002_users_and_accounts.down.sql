@@ -0,0 +1,29 @@
+-- The ids of users stay in the events and preferences that refer to them,
+-- as in the original schema.
+
+DROP TABLE http_session;
+DROP TABLE password_reset;
+DROP TABLE local_account;
+DROP TABLE calendar_feed;
+DROP TABLE api_token;
+
+ALTER TABLE user_events DROP CONSTRAINT user_events_user_id_fkey;
+ALTER TABLE user_event_types DROP CONSTRAINT user_event_types_user_id_fkey;
+ALTER TABLE user_event_topics DROP CONSTRAINT user_event_topics_user_id_fkey;
+
+DROP INDEX event_search_idx;
+DROP INDEX event_creator_idx;
+DROP INDEX event_created_idx;
+DROP INDEX event_start_idx;
+
+ALTER TABLE event
+    DROP CONSTRAINT event_creator_id_fkey,
+    ALTER COLUMN user_count DROP DEFAULT,
+    DROP COLUMN sequence,
+    DROP COLUMN created_at,
+    DROP COLUMN updated_at,
+    DROP COLUMN hidden_at,
+    DROP COLUMN hidden_by,
+    DROP COLUMN search_vector;
+
+DROP TABLE users;
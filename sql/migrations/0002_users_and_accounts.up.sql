@@ -0,0 +1,138 @@
+-- The changes made to sql/schema.sql after the original schema and before
+-- migrations were introduced: users and their roles, event history and
+-- moderation, search, API tokens, calendar feeds, local accounts and
+-- database sessions.
+
+CREATE TABLE users (
+    -- id is the id given by the user's identity provider, or 'local|' and
+    -- the id of the user's local_account
+    id             varchar PRIMARY KEY,
+    display_name   varchar NOT NULL DEFAULT '',
+    email          varchar NOT NULL DEFAULT '',
+    picture        varchar NOT NULL DEFAULT '',
+    -- role decides what the user may do, see db.Role
+    role           varchar NOT NULL DEFAULT 'member',
+    created_at     timestamptz NOT NULL DEFAULT now(),
+    last_login_at  timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT valid_role CHECK (role IN ('member', 'organizer', 'moderator', 'admin'))
+);
+
+-- Users were only known by the ids stored with their events and
+-- preferences, which become references to users. Their profiles are filled
+-- in when they next log in.
+UPDATE event SET creator_id = NULL WHERE creator_id = '';
+INSERT INTO users (id)
+    SELECT creator_id FROM event WHERE creator_id IS NOT NULL
+    UNION SELECT user_id FROM user_event_topics
+    UNION SELECT user_id FROM user_event_types
+    UNION SELECT user_id FROM user_events;
+
+UPDATE event SET user_count = 0 WHERE user_count IS NULL;
+
+ALTER TABLE event
+    ADD CONSTRAINT event_creator_id_fkey FOREIGN KEY (creator_id) REFERENCES users ON DELETE CASCADE,
+    ALTER COLUMN user_count SET DEFAULT 0,
+    -- sequence counts the updates made to this event, as used by iCalendar
+    ADD COLUMN sequence integer NOT NULL DEFAULT 0,
+    ADD COLUMN created_at timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN updated_at timestamptz NOT NULL DEFAULT now(),
+    -- hidden_at is set when a moderator hides the event from everyone but
+    -- its creator and the moderators
+    ADD COLUMN hidden_at timestamptz,
+    -- hidden_by is the id of the moderator that hid the event
+    ADD COLUMN hidden_by varchar REFERENCES users ON DELETE SET NULL,
+    -- search_vector indexes the searchable text of the event, weighting
+    -- matches in the title above the description and location
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', COALESCE(title, '')), 'A') ||
+        setweight(to_tsvector('english', COALESCE(description, '')), 'B') ||
+        setweight(to_tsvector('english', COALESCE(location, '')), 'C')
+    ) STORED;
+
+-- event_start_idx supports listing upcoming events in start order with
+-- keyset pagination on (start_timestamp, id)
+CREATE INDEX event_start_idx ON event (start_timestamp, id);
+
+-- event_created_idx supports listing the newest events in feeds
+CREATE INDEX event_created_idx ON event (created_at DESC, id DESC);
+
+-- event_creator_idx supports listing the events a user organizes
+CREATE INDEX event_creator_idx ON event (creator_id, start_timestamp, id);
+
+-- event_search_idx supports full-text search over events
+CREATE INDEX event_search_idx ON event USING GIN (search_vector);
+
+ALTER TABLE user_event_topics
+    ADD CONSTRAINT user_event_topics_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+ALTER TABLE user_event_types
+    ADD CONSTRAINT user_event_types_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+ALTER TABLE user_events
+    ADD CONSTRAINT user_events_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+
+CREATE TABLE api_token (
+    id            SERIAL PRIMARY KEY,
+    -- user_id is the id of the user that owns this token
+    user_id       varchar NOT NULL REFERENCES users ON DELETE CASCADE,
+    name          varchar NOT NULL,
+    -- scopes is a comma separated list of the scopes granted to this token
+    scopes        varchar NOT NULL,
+    -- token_hash is the hex encoded SHA-256 hash of the token; the token
+    -- itself is never stored
+    token_hash    varchar NOT NULL,
+    created_at    timestamptz NOT NULL DEFAULT now(),
+    last_used_at  timestamptz,
+    revoked_at    timestamptz,
+    CONSTRAINT uniq_token_hash UNIQUE(token_hash)
+);
+
+CREATE INDEX api_token_user_idx ON api_token (user_id);
+
+CREATE TABLE calendar_feed (
+    -- user_id is the id of the user these feeds belong to
+    user_id   varchar PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    -- feed_key is the secret part of the user's calendar feed URLs
+    feed_key  varchar NOT NULL,
+    CONSTRAINT uniq_feed_key UNIQUE(feed_key)
+);
+
+CREATE TABLE local_account (
+    -- the account's user id is 'local|' followed by id
+    id             SERIAL PRIMARY KEY,
+    -- email is stored lower case
+    email          varchar NOT NULL,
+    -- password_hash is the bcrypt hash of the password
+    password_hash  varchar NOT NULL,
+    given_name     varchar NOT NULL DEFAULT '',
+    family_name    varchar NOT NULL DEFAULT '',
+    -- failed_logins counts the failed logins since the last successful one
+    failed_logins  integer NOT NULL DEFAULT 0,
+    -- locked_until is set after too many failed logins in a row
+    locked_until   timestamptz,
+    created_at     timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT uniq_account_email UNIQUE(email)
+);
+
+CREATE TABLE password_reset (
+    account_id  integer NOT NULL REFERENCES local_account ON DELETE CASCADE,
+    -- token_hash is the hex encoded SHA-256 hash of the emailed token
+    token_hash  varchar PRIMARY KEY,
+    expires_at  timestamptz NOT NULL,
+    used_at     timestamptz
+);
+
+CREATE INDEX password_reset_account_idx ON password_reset (account_id);
+
+CREATE TABLE http_session (
+    -- id is the random session id kept in the session cookie
+    id          varchar PRIMARY KEY,
+    -- user_id is the id of the logged in user, if any
+    user_id     varchar REFERENCES users ON DELETE CASCADE,
+    -- data holds the encoded session values
+    data        text NOT NULL,
+    created_at  timestamptz NOT NULL DEFAULT now(),
+    updated_at  timestamptz NOT NULL DEFAULT now(),
+    expires_at  timestamptz NOT NULL
+);
+
+CREATE INDEX http_session_user_idx ON http_session (user_id);
+CREATE INDEX http_session_expires_idx ON http_session (expires_at);
@@ -0,0 +1,8 @@
+-- Event times go back to wall clock times without a zone, each in the zone
+-- of its event.
+ALTER TABLE event
+    ALTER COLUMN start_timestamp TYPE timestamp USING start_timestamp AT TIME ZONE time_zone,
+    ALTER COLUMN end_timestamp TYPE timestamp USING end_timestamp AT TIME ZONE time_zone;
+
+ALTER TABLE event DROP COLUMN time_zone;
+ALTER TABLE users DROP COLUMN time_zone;
@@ -0,0 +1,28 @@
+-- Event times become instants with the IANA time zone of each event, and
+-- users may choose the zone they see times in.
+--
+-- Event times were stored without a zone, as wall clock times in the app's
+-- time_zone setting, which 'protestpulse migrate' passes in as
+-- protestpulse.time_zone. It is checked here so that a missing or invalid
+-- zone stops the migration instead of shifting every event.
+DO $$
+DECLARE
+    zone text := current_setting('protestpulse.time_zone', true);
+BEGIN
+    IF zone IS NULL OR zone IN ('', 'localtime', 'posixrules')
+            OR NOT EXISTS (SELECT 1 FROM pg_timezone_names WHERE name = zone) THEN
+        RAISE EXCEPTION 'protestpulse.time_zone must be set to the IANA time zone of the app, not %', quote_nullable(zone);
+    END IF;
+END $$;
+
+-- time_zone is the IANA name of the zone the event takes place in
+ALTER TABLE event ADD COLUMN time_zone varchar NOT NULL DEFAULT current_setting('protestpulse.time_zone');
+ALTER TABLE event ALTER COLUMN time_zone DROP DEFAULT;
+
+ALTER TABLE event
+    ALTER COLUMN start_timestamp TYPE timestamptz USING start_timestamp AT TIME ZONE time_zone,
+    ALTER COLUMN end_timestamp TYPE timestamptz USING end_timestamp AT TIME ZONE time_zone;
+
+-- time_zone is the IANA name of the zone the user sees times in, or empty
+-- for the app's time_zone setting
+ALTER TABLE users ADD COLUMN time_zone varchar NOT NULL DEFAULT '';
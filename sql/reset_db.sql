@@ -0,0 +1,15 @@
+-- Drops and recreates the empty ppdb database and its ppmaster owner for
+-- development. The tables are created by the migrations in sql/migrations,
+-- applied with 'protestpulse migrate up'.
+
+DROP DATABASE IF EXISTS ppdb;
+CREATE DATABASE ppdb;
+
+DROP OWNED BY ppmaster CASCADE;
+REVOKE ALL ON ALL TABLES IN SCHEMA public FROM PUBLIC;
+
+DROP USER IF EXISTS ppmaster;
+CREATE USER ppmaster;
+
+ALTER DATABASE ppdb OWNER TO ppmaster;
+GRANT ALL PRIVILEGES ON DATABASE ppdb TO ppmaster;
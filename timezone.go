@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// humanTimeFormat is the layout of event times shown on pages, which names
+// the zone the time is shown in.
+var humanTimeFormat = humanDateFormat + " 3:04 PM MST"
+
+// timeZones are the zones suggested by the time zone inputs, which also
+// accept any other IANA zone name.
+var timeZones = []string{
+	"Pacific/Honolulu",
+	"America/Anchorage",
+	"America/Los_Angeles",
+	"America/Denver",
+	"America/Phoenix",
+	"America/Chicago",
+	"America/New_York",
+	"America/Halifax",
+	"America/Mexico_City",
+	"America/Bogota",
+	"America/Sao_Paulo",
+	"America/Argentina/Buenos_Aires",
+	"UTC",
+	"Europe/London",
+	"Europe/Dublin",
+	"Europe/Lisbon",
+	"Europe/Paris",
+	"Europe/Berlin",
+	"Europe/Madrid",
+	"Europe/Rome",
+	"Europe/Athens",
+	"Europe/Istanbul",
+	"Europe/Moscow",
+	"Africa/Lagos",
+	"Africa/Cairo",
+	"Africa/Johannesburg",
+	"Africa/Nairobi",
+	"Asia/Dubai",
+	"Asia/Karachi",
+	"Asia/Kolkata",
+	"Asia/Dhaka",
+	"Asia/Bangkok",
+	"Asia/Jakarta",
+	"Asia/Shanghai",
+	"Asia/Hong_Kong",
+	"Asia/Singapore",
+	"Asia/Manila",
+	"Asia/Seoul",
+	"Asia/Tokyo",
+	"Australia/Perth",
+	"Australia/Sydney",
+	"Pacific/Auckland",
+}
+
+// zones caches the zones returned by loadZone, since time.LoadLocation
+// reads the zone database on every call.
+var zones sync.Map
+
+// loadZone returns the time zone with the given IANA name. Unlike
+// time.LoadLocation, it rejects the empty name, "Local" and "localtime",
+// which do not name a zone that means the same to every viewer.
+func loadZone(name string) (*time.Location, error) {
+	if loc, ok := zones.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	if name == "" || name == "Local" || name == "localtime" {
+		return nil, fmt.Errorf("Unknown time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown time zone %q", name)
+	}
+	zones.Store(name, loc)
+	return loc, nil
+}
+
+// zoneOr returns the time zone with the given name, or def if there is no
+// such zone.
+func zoneOr(name string, def *time.Location) *time.Location {
+	loc, err := loadZone(name)
+	if err != nil {
+		return def
+	}
+	return loc
+}
+
+// eventZone returns the time zone an event with the given zone name takes
+// place in, falling back to the app's default zone.
+func (a *App) eventZone(name string) *time.Location {
+	return zoneOr(name, a.location)
+}
+
+// viewerZone returns the time zone the user of the request sees times in:
+// the zone they chose in their preferences, or the app's default zone for
+// anonymous visitors and users who have not chosen one.
+func (a *App) viewerZone(r *http.Request) *time.Location {
+	p := requestProfile(r)
+	if p == nil {
+		return a.location
+	}
+	u, err := a.user(r, p)
+	if err != nil {
+		return a.location
+	}
+	return zoneOr(u.TimeZone, a.location)
+}
+
+// formatInZones formats t as the local time of an event taking place in
+// zone, and as the time for a viewer in viewer. The viewer's time is empty
+// if it reads the same as the local time.
+func formatInZones(t time.Time, zone, viewer *time.Location) (local, yours string) {
+	local = t.In(zone).Format(humanTimeFormat)
+	yours = t.In(viewer).Format(humanTimeFormat)
+	if yours == local {
+		yours = ""
+	}
+	return local, yours
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chloearianne/protestpulse/db"
+)
+
+func TestEventTimeZones(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	organizer := ts.login("organizer", db.RoleOrganizer)
+	member := ts.login("member", db.RoleMember)
+
+	for _, tt := range []struct {
+		u    *testUser
+		zone string
+	}{{organizer, "America/Los_Angeles"}, {member, "America/New_York"}} {
+		if rec := ts.submit("POST", "/preferences", url.Values{"time_zone": {tt.zone}}, tt.u); rec.Code != http.StatusSeeOther {
+			t.Fatalf("save time zone %s: status = %d", tt.zone, rec.Code)
+		}
+	}
+
+	// Events from the form take place in the organizer's zone by default.
+	form := url.Values{
+		"title":       {"Coast march"},
+		"location":    {"Santa Monica Pier"},
+		"event_type":  {"1"},
+		"event_topic": {"2"},
+		"start_date":  {testDate(0)},
+		"start_time":  {"10:00"},
+		"end_date":    {testDate(0)},
+		"end_time":    {"12:00"},
+	}
+	if rec := ts.submit("POST", "/events", form, organizer); rec.Code != http.StatusSeeOther {
+		t.Fatalf("create: status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	events, err := ts.app.store.EventsCreatedBy("organizer")
+	if err != nil || len(events) != 1 {
+		t.Fatalf("events = %+v, err %v", events, err)
+	}
+	e := events[0]
+	if want := testDay.Add(17 * time.Hour); e.TimeZone != "America/Los_Angeles" || !e.Start.Equal(want) {
+		t.Fatalf("start = %v in %q, want %v in America/Los_Angeles", e.Start, e.TimeZone, want)
+	}
+
+	// Pages show the event's local time, and the viewer's time if it differs.
+	path := fmt.Sprintf("/events/%d", e.ID)
+	day := testDay.Format(humanDateFormat)
+	tests := []struct {
+		name     string
+		path     string
+		u        *testUser
+		contains []string
+		excludes string
+	}{
+		{"event page of organizer", path, organizer, []string{day + " 10:00 AM PDT"}, "your time"},
+		{"event page of member", path, member, []string{day + " 10:00 AM PDT", day + " 1:00 PM EDT your time"}, ""},
+		{"events page of anonymous visitor", "/events", nil, []string{day + " 10:00 AM PDT", day + " 5:00 PM UTC your time"}, ""},
+		{"edit form", path, organizer, []string{`name="start_time" value="10:00"`, `name="time_zone" value="America/Los_Angeles"`}, ""},
+		{"calendar", path + ".ics", nil, []string{"DTSTART;TZID=America/Los_Angeles:" + testDay.Format("20060102") + "T100000"}, ""},
+	}
+	for _, tt := range tests {
+		body := ts.get(tt.path, tt.u).Body.String()
+		for _, want := range tt.contains {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s does not contain %q", tt.name, want)
+			}
+		}
+		if tt.excludes != "" && strings.Contains(body, tt.excludes) {
+			t.Errorf("%s contains %q", tt.name, tt.excludes)
+		}
+	}
+
+	// Editing the zone in the form keeps the wall clock times.
+	form.Set("time_zone", "America/Chicago")
+	if rec := ts.submit("POST", path, form, organizer); rec.Code != http.StatusSeeOther {
+		t.Fatalf("edit: status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	edited, err := ts.app.store.GetEvent(e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testDay.Add(15 * time.Hour); !edited.Start.Equal(want) {
+		t.Errorf("start after edit = %v, want %v", edited.Start, want)
+	}
+
+	// API clients send instants and may name the zone.
+	event := map[string]interface{}{
+		"title": "Tokyo rally", "start": testDate(31) + "T10:00:00+09:00", "end": testDate(31) + "T12:00:00+09:00",
+		"time_zone": "Asia/Tokyo", "type": 1, "topic": 1, "location": "Shibuya",
+	}
+	rec := ts.sendJSON("POST", "/api/v1/events", event, organizer)
+	var created db.Event
+	decodeJSON(t, rec, &created)
+	if rec.Code != http.StatusCreated || created.TimeZone != "Asia/Tokyo" || !created.Start.Equal(testDay.AddDate(0, 0, 31).Add(time.Hour)) {
+		t.Errorf("API create: status = %d, event %+v", rec.Code, created)
+	}
+}
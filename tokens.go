@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/session"
+	"github.com/gorilla/mux"
+)
+
+// apiTokenPrefix starts every API token so that they are easy to recognize,
+// for example by secret scanners.
+const apiTokenPrefix = "pp_"
+
+// errTokenNotAllowed is returned for requests that must not be
+// authenticated with an API token.
+var errTokenNotAllowed = errors.New("API tokens cannot be used for this request")
+
+// newAPIToken generates a random API token and returns it along with the
+// hash under which it is stored.
+func newAPIToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hash under which a token, such as an API token or a
+// password reset token, is stored. Tokens are long and random, so a fast
+// hash is sufficient.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the token from an 'Authorization: Bearer' header, or
+// an empty string if there is none.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[7:])
+}
+
+// authenticateToken looks up the API token of the request and returns it
+// if it is valid and grants the scope the request needs. On failure it
+// returns the HTTP status describing the error.
+func (a *App) authenticateToken(r *http.Request, token string) (*db.APIToken, int, string) {
+	t, err := a.store.UseAPIToken(hashToken(token))
+	if err == sql.ErrNoRows {
+		return nil, http.StatusUnauthorized, "Invalid or revoked API token"
+	}
+	if err != nil {
+		requestLog(r).WithError(err).Error("Failed to look up API token")
+		return nil, http.StatusInternalServerError, err.Error()
+	}
+
+	scope := db.ScopeWrite
+	if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+		scope = db.ScopeRead
+	}
+	if !t.HasScope(scope) {
+		return nil, http.StatusForbidden, "API token lacks the " + scope + " scope"
+	}
+	return t, http.StatusOK, ""
+}
+
+// isTokenRequest reports whether the request was authenticated with an API
+// token rather than the auth-session.
+func isTokenRequest(r *http.Request) bool {
+	return requestAPIToken(r) != nil
+}
+
+// TokensGET handles GET requests for '/settings/tokens' by listing the
+// user's API tokens.
+func (a *App) TokensGET(w http.ResponseWriter, r *http.Request) {
+	a.renderTokens(w, r, "")
+}
+
+// TokensPOST handles POST requests for '/settings/tokens' by creating an API
+// token. The token is shown once and cannot be retrieved afterwards.
+func (a *App) TokensPOST(w http.ResponseWriter, r *http.Request) {
+	p, err := a.browserProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	r.ParseForm()
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "A token name is required", http.StatusBadRequest)
+		return
+	}
+	scopes := []string{db.ScopeRead}
+	if r.FormValue("write") != "" {
+		scopes = append(scopes, db.ScopeWrite)
+	}
+
+	token, hash, err := newAPIToken()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to generate API token")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err = a.store.CreateAPIToken(p.UserID, name, scopes, hash); err != nil {
+		logrus.WithError(err).Error("Failed to save API token")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a.renderTokens(w, r, token)
+}
+
+// TokenRevokePOST handles POST requests for '/settings/tokens/{id}/revoke'.
+func (a *App) TokenRevokePOST(w http.ResponseWriter, r *http.Request) {
+	p, err := a.browserProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err = a.store.RevokeAPIToken(p.UserID, id); err != nil {
+		logrus.WithError(err).Error("Failed to revoke API token")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/tokens", http.StatusSeeOther)
+}
+
+// renderTokens renders the token settings page, including a newly created
+// token if there is one.
+func (a *App) renderTokens(w http.ResponseWriter, r *http.Request, newToken string) {
+	p, err := a.browserProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	tokens, err := a.store.APITokens(p.UserID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get API tokens")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Page":     "Settings",
+		"Tokens":   tokens,
+		"NewToken": newToken,
+	}
+	a.renderTemplate(w, r, "tokens.tmpl", data)
+}
+
+// browserProfile returns the profile of a user logged in through the
+// auth-session. API tokens cannot be used to manage API tokens.
+func (a *App) browserProfile(r *http.Request) (*session.Profile, error) {
+	if isTokenRequest(r) {
+		return nil, errTokenNotAllowed
+	}
+	return requestProfile(r), nil
+}
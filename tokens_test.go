@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/chloearianne/protestpulse/db"
+)
+
+// tokenPattern matches API tokens shown on the token settings page.
+var tokenPattern = regexp.MustCompile(apiTokenPrefix + `[\w-]+`)
+
+// createToken creates an API token for u through the token settings page
+// and returns it.
+func createToken(t *testing.T, ts *testServer, u *testUser, form url.Values) string {
+	rec := ts.submit("POST", "/settings/tokens", form, u)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create token: status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	token := tokenPattern.FindString(rec.Body.String())
+	if token == "" {
+		t.Fatal("created token is not shown")
+	}
+	return token
+}
+
+// tokenRequest serves an API request authenticated with token only.
+func tokenRequest(ts *testServer, method, path string, v interface{}, token string) int {
+	r := jsonRequest(ts.t, method, path, v)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return ts.serve(r, nil).Code
+}
+
+func TestAPITokens(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	organizer := ts.login("organizer", db.RoleOrganizer)
+
+	readOnly := createToken(t, ts, organizer, url.Values{"name": {"reader"}})
+	readWrite := createToken(t, ts, organizer, url.Values{"name": {"writer"}, "write": {"on"}})
+	event := map[string]interface{}{
+		"title": "Token march", "start": testDate(0) + "T10:00:00Z", "end": testDate(0) + "T12:00:00Z",
+		"type": 1, "topic": 1, "location": "Online",
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		token  string
+		status int
+	}{
+		{"read with read token", "GET", "/api/v1/me/events", nil, readOnly, http.StatusOK},
+		{"write with read token", "POST", "/api/v1/events", event, readOnly, http.StatusForbidden},
+		{"write with write token", "POST", "/api/v1/events", event, readWrite, http.StatusCreated},
+		{"unknown token", "GET", "/api/v1/me/events", nil, apiTokenPrefix + "unknown", http.StatusUnauthorized},
+		{"page with token", "GET", "/settings/tokens", nil, readWrite, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if status := tokenRequest(ts, tt.method, tt.path, tt.body, tt.token); status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+		}
+	}
+
+	// Revoked tokens stop working.
+	tokens, err := ts.app.store.APITokens("organizer")
+	if err != nil || len(tokens) != 2 {
+		t.Fatalf("tokens = %+v, err %v", tokens, err)
+	}
+	for _, tok := range tokens {
+		path := fmt.Sprintf("/settings/tokens/%d/revoke", tok.ID)
+		if rec := ts.serve(formRequest("POST", path, nil), organizer); rec.Code != http.StatusSeeOther {
+			t.Fatalf("revoke: status = %d", rec.Code)
+		}
+	}
+	if status := tokenRequest(ts, "GET", "/api/v1/me/events", nil, readWrite); status != http.StatusUnauthorized {
+		t.Errorf("revoked token: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/chloearianne/protestpulse/db"
+	"github.com/chloearianne/protestpulse/session"
+	"github.com/gorilla/mux"
+)
+
+// UserGET handles GET requests for '/users/{id}', the public profile page
+// of a user listing the upcoming events they organize. Clients that accept
+// JSON receive the profile as JSON instead of HTML.
+func (a *App) UserGET(w http.ResponseWriter, r *http.Request) {
+	u, err := a.store.GetUser(mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get user")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := db.EventFilter{Creator: u.ID, Limit: eventsPageSize}
+	if c := q.Get("after"); c != "" {
+		if filter.After, err = db.ParseCursor(c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	events, next, err := a.store.ListEvents(filter)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list events")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if wantsJSON(r) {
+		resp := map[string]interface{}{
+			"user": map[string]interface{}{
+				"id":           u.ID,
+				"display_name": u.DisplayName,
+				"picture":      u.Picture,
+				"created_at":   u.CreatedAt,
+			},
+			"events": events,
+		}
+		if next != nil {
+			resp["next"] = next.String()
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	var nextURL template.URL
+	if next != nil {
+		q.Set("after", next.String())
+		nextURL = template.URL(userURL(u.ID) + "?" + q.Encode())
+	}
+
+	data := map[string]interface{}{
+		"Page":        "Profile",
+		"User":        u,
+		"MemberSince": u.CreatedAt.Format(humanDateFormat),
+		"Events":      toEvents(events, a.viewerZone(r)),
+		"NextURL":     nextURL,
+	}
+	if a.viewerRole(r).CanAdminister() {
+		data["CanAdminister"] = true
+		data["Roles"] = db.Roles
+		_, data["CanRevokeSessions"] = a.sessionStore.(session.Revoker)
+	}
+	a.renderTemplate(w, r, "user.tmpl", data)
+}
+
+// userURL returns the path of the public profile page of the user with
+// the given id.
+func userURL(id string) string {
+	return "/users/" + url.PathEscape(id)
+}
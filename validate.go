@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chloearianne/protestpulse/db"
+)
+
+// fieldErrors maps the names of invalid event fields, as used by the JSON
+// API, to a description of the problem with each.
+type fieldErrors map[string]string
+
+// eventFields lists the event fields in the order of the event forms.
+var eventFields = []string{"title", "type", "topic", "description", "location", "time_zone", "start", "end"}
+
+// add records msg for field, unless a problem was already found with it.
+func (errs fieldErrors) add(field, msg string) {
+	if _, ok := errs[field]; !ok {
+		errs[field] = msg
+	}
+}
+
+// String joins the problems in the order of the event forms, for responses
+// that cannot show them next to each field.
+func (errs fieldErrors) String() string {
+	var msgs []string
+	for _, field := range eventFields {
+		if msg, ok := errs[field]; ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	return strings.Join(msgs, ". ")
+}
+
+// eventForm is the state of the create event modal or the edit form of an
+// event: the values the fields show, and when it is shown again after a
+// failed submission, the problems found with them.
+type eventForm struct {
+	Values url.Values
+	Errors fieldErrors
+}
+
+// TimeZones returns the zones suggested by the time zone input of the form.
+func (f *eventForm) TimeZones() []string {
+	return timeZones
+}
+
+// validateEvent checks e, an event with the submitted fields of in applied
+// to it, and returns the problems found, including those found while
+// reading in. Start times must be in the future unless they equal
+// oldStart, the start of the event before the changes, so that past events
+// can still be edited.
+func (a *App) validateEvent(in *eventInput, e *db.Event, oldStart time.Time) (fieldErrors, error) {
+	topics, types, err := a.lookups()
+	if err != nil {
+		return nil, err
+	}
+
+	errs := fieldErrors{}
+	for field, msg := range in.errs {
+		errs[field] = msg
+	}
+
+	checkText(errs, "title", "Title", e.Title, db.MaxTitleLength, true)
+	checkText(errs, "location", "Location", e.Location, db.MaxLocationLength, true)
+	checkText(errs, "description", "Description", e.Description, db.MaxDescriptionLength, false)
+	checkLookup(errs, "type", e.Type, types)
+	checkLookup(errs, "topic", e.Topic, topics)
+	if e.TimeZone == "" {
+		errs.add("time_zone", "Time zone is required")
+	} else if _, err := loadZone(e.TimeZone); err != nil {
+		errs.add("time_zone", err.Error())
+	}
+
+	switch {
+	case e.Start.IsZero():
+		errs.add("start", "Start time is required")
+	case !e.Start.Equal(oldStart) && !e.Start.After(time.Now()):
+		errs.add("start", "Start time must be in the future")
+	}
+	switch {
+	case e.End.IsZero():
+		errs.add("end", "End time is required")
+	case !e.Start.IsZero() && !e.End.After(e.Start):
+		errs.add("end", "End time must be after the start time")
+	}
+	return errs, nil
+}
+
+// checkText records a problem with a text field that is blank while
+// required, or longer than max characters.
+func checkText(errs fieldErrors, field, label, v string, max int, required bool) {
+	if required && strings.TrimSpace(v) == "" {
+		errs.add(field, fmt.Sprintf("%s is required", label))
+	} else if utf8.RuneCountInString(v) > max {
+		errs.add(field, fmt.Sprintf("%s must be at most %d characters", label, max))
+	}
+}
+
+// checkLookup records a problem with a topic or type id that was not
+// chosen or is not one of lookups.
+func checkLookup(errs fieldErrors, field string, id int, lookups []db.Lookup) {
+	if id == 0 {
+		errs.add(field, fmt.Sprintf("Choose a %s", field))
+	} else if !hasLookup(lookups, id) {
+		errs.add(field, fmt.Sprintf("Unknown %s %d", field, id))
+	}
+}
+
+// writeFieldErrors writes the problems with a submitted event as a 422
+// response in the JSON API's error format.
+func writeFieldErrors(w http.ResponseWriter, errs fieldErrors) {
+	status := http.StatusUnprocessableEntity
+	writeJSON(w, status, map[string]apiError{
+		"error": {Status: status, Message: "Invalid event fields", Fields: errs},
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chloearianne/protestpulse/db"
+)
+
+func TestValidateEvent(t *testing.T) {
+	a := newTestApp()
+	start := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Minute)
+	past := time.Date(2000, 1, 1, 10, 0, 0, 0, time.UTC)
+	valid := func() db.Event {
+		return db.Event{
+			Title: "March", Location: "City Hall", Type: 1, Topic: 2,
+			Start: start, End: start.Add(time.Hour), TimeZone: "America/New_York",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		change   func(e *db.Event)
+		oldStart time.Time
+		want     fieldErrors
+	}{
+		{"valid", func(e *db.Event) {}, time.Time{}, fieldErrors{}},
+		{"blank", func(e *db.Event) { *e = db.Event{Title: "  "} }, time.Time{}, fieldErrors{
+			"title": "Title is required", "location": "Location is required", "type": "Choose a type",
+			"topic": "Choose a topic", "time_zone": "Time zone is required",
+			"start": "Start time is required", "end": "End time is required",
+		}},
+		{"unknown time zone", func(e *db.Event) { e.TimeZone = "Mars/Olympus_Mons" }, time.Time{},
+			fieldErrors{"time_zone": `Unknown time zone "Mars/Olympus_Mons"`}},
+		{"too long", func(e *db.Event) { e.Description = strings.Repeat("x", db.MaxDescriptionLength+1) }, time.Time{},
+			fieldErrors{"description": "Description must be at most 5000 characters"}},
+		{"long in bytes only", func(e *db.Event) { e.Title = strings.Repeat("é", db.MaxTitleLength) }, time.Time{}, fieldErrors{}},
+		{"too long in characters", func(e *db.Event) { e.Title = strings.Repeat("é", db.MaxTitleLength+1) }, time.Time{},
+			fieldErrors{"title": "Title must be at most 200 characters"}},
+		{"unknown lookups", func(e *db.Event) { e.Type, e.Topic = 9, 99 }, time.Time{},
+			fieldErrors{"type": "Unknown type 9", "topic": "Unknown topic 99"}},
+		{"end before start", func(e *db.Event) { e.End = e.Start.Add(-time.Minute) }, time.Time{},
+			fieldErrors{"end": "End time must be after the start time"}},
+		{"past start", func(e *db.Event) { e.Start, e.End = past, past.Add(time.Hour) }, time.Time{},
+			fieldErrors{"start": "Start time must be in the future"}},
+		{"unchanged past start", func(e *db.Event) { e.Start, e.End = past, past.Add(time.Hour) }, past, fieldErrors{}},
+	}
+	for _, tt := range tests {
+		e := valid()
+		tt.change(&e)
+		errs, err := a.validateEvent(&eventInput{}, &e, tt.oldStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) != len(tt.want) {
+			t.Errorf("%s: errors = %v, want %v", tt.name, errs, tt.want)
+			continue
+		}
+		for field, msg := range tt.want {
+			if errs[field] != msg {
+				t.Errorf("%s: %s error = %q, want %q", tt.name, field, errs[field], msg)
+			}
+		}
+	}
+}
+
+func TestCreateEventFormErrors(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	organizer := ts.login("organizer", db.RoleOrganizer)
+
+	form := url.Values{
+		"title":       {"Bike ride"},
+		"location":    {"Riverside"},
+		"event_type":  {"1"},
+		"event_topic": {"Select a category"},
+		"start_date":  {testDate(0)},
+		"start_time":  {"10:00"},
+		"end_date":    {testDate(0)},
+		"end_time":    {"09:00"},
+	}
+	rec := ts.submit("POST", "/events", form, organizer)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`name="title" value="Bike ride"`,
+		`name="end_time" value="09:00"`,
+		`<option value="1" selected>In Person</option>`,
+		"Choose a topic from the list",
+		"End time must be after the start time",
+		"$('#eventModal').modal('show')",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("form shown again does not contain %q", want)
+		}
+	}
+	if events, _ := ts.app.store.EventsCreatedBy("organizer"); len(events) != 0 {
+		t.Errorf("invalid event was created: %+v", events)
+	}
+
+	// JSON clients receive the problems by field.
+	form.Set("event_topic", "2")
+	r := formRequest("POST", "/events", form)
+	r.Header.Set("Accept", "application/json")
+	rec = ts.serve(r, organizer)
+	var resp struct{ Error apiError }
+	decodeJSON(t, rec, &resp)
+	if rec.Code != http.StatusUnprocessableEntity || resp.Error.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, error %+v", rec.Code, resp.Error)
+	}
+	if len(resp.Error.Fields) != 1 || resp.Error.Fields["end"] == "" {
+		t.Errorf("fields = %v, want only end", resp.Error.Fields)
+	}
+
+	// Once valid, they receive the created event.
+	form.Set("end_time", "12:00")
+	r = formRequest("POST", "/events", form)
+	r.Header.Set("Accept", "application/json")
+	rec = ts.serve(r, organizer)
+	var created db.Event
+	decodeJSON(t, rec, &created)
+	if rec.Code != http.StatusCreated || created.Title != "Bike ride" || rec.Header().Get("Location") != fmt.Sprintf("/events/%d", created.ID) {
+		t.Errorf("status = %d, Location %q, event %+v", rec.Code, rec.Header().Get("Location"), created)
+	}
+}
+
+func TestEditEventFormErrors(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	owner := ts.login("owner", db.RoleOrganizer)
+	e := ts.createEvent("owner", "Bike ride")
+
+	form := url.Values{"title": {"Night ride"}, "end_date": {"2000-01-01"}, "end_time": {"10:00"}}
+	rec := ts.submit("POST", fmt.Sprintf("/events/%d", e.ID), form, owner)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`name="title" value="Night ride"`,
+		`name="end_date" value="2000-01-01"`,
+		`name="location" value="` + e.Location + `"`,
+		"The changes could not be saved",
+		"End time must be after the start time",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("edit form shown again does not contain %q", want)
+		}
+	}
+	if got, _ := ts.app.store.GetEvent(e.ID); got.Title != "Bike ride" {
+		t.Errorf("invalid changes were saved: title = %q", got.Title)
+	}
+}